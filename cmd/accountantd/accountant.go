@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// outcome describes what happened to a payment.
+type outcome int
+
+const (
+	// outcomeApplied means the payment changed the account balance.
+	outcomeApplied outcome = iota
+	// outcomeDuplicate means the payment was skipped because it had been seen before.
+	outcomeDuplicate
+	// outcomeRejected means the payment would overdraw the account.
+	outcomeRejected
+	// outcomeSkipped means the payment couldn't be applied and the error policy skipped it.
+	outcomeSkipped
+)
+
+// accountant applies payments to account balances skipping duplicates and
+// rejecting outgoing payments which would overdraw accounts.
+type accountant struct {
+	dedup      wallet.DedupService
+	balance    wallet.BalanceService
+	ledger     wallet.LedgerService
+	rejections wallet.RejectionService
+	events     wallet.TransferEventService
+	// changes is where balance changes are published, so other services could read current balances.
+	changes wallet.BalanceChangeService
+	logger  wallet.Logger
+	// overdraft is a set of accounts allowed to have a negative balance,
+	// e.g., a bank account which funds customers' wallets.
+	overdraft map[string]bool
+}
+
+// apply updates the account balance affected by the payment and returns the balance in the payment's currency.
+// An outgoing payment which would overdraw the account is rejected, reversals are never rejected.
+func (a *accountant) apply(ctx context.Context, p *wallet.Payment) (apd.Decimal, outcome, error) {
+	var bal apd.Decimal
+	// Payments created before IDs were introduced don't have them.
+	if p.ID == "" {
+		p.ID = wallet.PaymentID(p.RequestID, p.Direction, p.Account)
+	}
+	if p.Currency == "" {
+		p.Currency = wallet.DefaultCurrency
+	}
+
+	seen, err := a.dedup.HasSeen(p.ID)
+	if err != nil {
+		return bal, outcomeDuplicate, errors.Wrap(err, "dedup")
+	}
+	if seen {
+		a.logger.Log("level", "debug", "msg", "skip payment", "payment", p.ID)
+		return bal, outcomeDuplicate, nil
+	}
+
+	if bal, err = a.balance.Balance(p.Account, p.Currency); err != nil {
+		return bal, outcomeDuplicate, errors.Wrap(err, "balance")
+	}
+	newBal, err := calcBalance(bal, p)
+	if err != nil {
+		return bal, outcomeDuplicate, err
+	}
+
+	if p.Direction == "outgoing" && p.Reverses == "" && newBal.Sign() < 0 && !a.overdraft[p.Account] {
+		// A lost rejection would leave the recipient's payment unreversed, so it's created before the payment is saved.
+		r := wallet.Rejection{
+			Payment: *p,
+			Reason:  wallet.ErrInsufficientFunds.Error(),
+		}
+		if err = a.rejections.CreateRejection(ctx, &r); err != nil {
+			return bal, outcomeRejected, errors.Wrap(err, "rejection")
+		}
+		if err = a.ledger.ApplyPayment(p, bal); err != nil {
+			return bal, outcomeRejected, errors.Wrap(err, "ledger")
+		}
+		if err = a.emit(ctx, p, wallet.StatusRejected); err != nil {
+			return bal, outcomeRejected, err
+		}
+		a.logger.Log("level", "debug", "msg", "payment rejected", "payment", p.ID, "balance", bal.Text('f'))
+		return bal, outcomeRejected, nil
+	}
+
+	// A lost balance change would leave the changelog stale, so it's published before the payment is saved.
+	// The change is published again if the payment isn't saved, which is harmless, since it carries the same balances.
+	if err = a.publish(ctx, p, newBal); err != nil {
+		return bal, outcomeApplied, err
+	}
+	if err = a.ledger.ApplyPayment(p, newBal); err != nil {
+		return bal, outcomeApplied, errors.Wrap(err, "ledger")
+	}
+
+	switch {
+	case p.Reverses != "":
+		// Reversal doesn't change the transfer status, it's been rejected already.
+	case p.Direction == "outgoing":
+		err = a.emit(ctx, p, wallet.StatusDebited)
+	default:
+		err = a.emit(ctx, p, wallet.StatusCredited)
+	}
+	if err != nil {
+		return newBal, outcomeApplied, err
+	}
+	return newBal, outcomeApplied, nil
+}
+
+// emit creates a transfer event with the given status caused by the payment.
+func (a *accountant) emit(ctx context.Context, p *wallet.Payment, status wallet.TransferStatus) error {
+	e := wallet.TransferEvent{
+		RequestID: p.RequestID,
+		Status:    status,
+		Time:      time.Now().UTC(),
+	}
+	if err := a.events.CreateEvent(ctx, &e); err != nil {
+		return errors.Wrap(err, "transfer event")
+	}
+	return nil
+}
+
+// publish creates a balance change with all balances of the payment's account.
+func (a *accountant) publish(ctx context.Context, p *wallet.Payment, bal apd.Decimal) error {
+	balances, err := a.balance.Balances(p.Account)
+	if err != nil {
+		return errors.Wrap(err, "balances")
+	}
+
+	b := wallet.BalanceChange{
+		Account:          p.Account,
+		PaymentPartition: p.Partition,
+		PaymentOffset:    p.SequenceID,
+	}
+	for _, old := range balances {
+		if old.Currency != p.Currency {
+			b.Balances = append(b.Balances, old)
+		}
+	}
+	b.Balances = append(b.Balances, wallet.Balance{Currency: p.Currency, Amount: bal})
+	sort.Slice(b.Balances, func(i, j int) bool { return b.Balances[i].Currency < b.Balances[j].Currency })
+
+	if err = a.changes.CreateBalanceChange(ctx, &b); err != nil {
+		return errors.Wrap(err, "balance change")
+	}
+	return nil
+}
+
+// calcBalance calculates account balance affected by a payment.
+func calcBalance(bal apd.Decimal, p *wallet.Payment) (apd.Decimal, error) {
+	dc := apd.BaseContext.WithPrecision(wallet.DecimalMaxDigits)
+
+	var newBal apd.Decimal
+	if p.Direction == "outgoing" {
+		if res, err := dc.Sub(&newBal, &bal, &p.Amount); err != nil {
+			return bal, errors.Wrapf(err, "outgoing payment: %v", res)
+		}
+		return newBal, nil
+	}
+
+	if res, err := dc.Add(&newBal, &bal, &p.Amount); err != nil {
+		return bal, errors.Wrapf(err, "incoming payment: %v", res)
+	}
+	return newBal, nil
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/memlog"
+	"github.com/marselester/distributed-payment/mock"
+)
+
+// newAccountant returns an accountant which keeps balances and seen payments in maps.
+// Balances are keyed by account and currency, e.g., "Alice/USD".
+// Rejections are appended to the rejections slice, transfer events and balance changes are discarded.
+func newAccountant(balances map[string]apd.Decimal, rejections *[]wallet.Rejection) *accountant {
+	seen := make(map[string]bool)
+	return &accountant{
+		dedup: &mock.DedupService{
+			HasSeenFn: func(id string) (bool, error) {
+				return seen[id], nil
+			},
+		},
+		balance: &mock.BalanceService{
+			BalanceFn: func(account, currency string) (apd.Decimal, error) {
+				return balances[account+"/"+currency], nil
+			},
+			BalancesFn: func(account string) ([]wallet.Balance, error) {
+				var bb []wallet.Balance
+				for key, bal := range balances {
+					if strings.HasPrefix(key, account+"/") {
+						bb = append(bb, wallet.Balance{Currency: key[len(account)+1:], Amount: bal})
+					}
+				}
+				return bb, nil
+			},
+		},
+		ledger: &mock.LedgerService{
+			ApplyPaymentFn: func(p *wallet.Payment, bal apd.Decimal) error {
+				seen[p.ID] = true
+				balances[p.Account+"/"+p.Currency] = bal
+				return nil
+			},
+		},
+		rejections: &mock.RejectionService{
+			CreateRejectionFn: func(_ context.Context, r *wallet.Rejection) error {
+				*rejections = append(*rejections, *r)
+				return nil
+			},
+		},
+		events:  &mock.TransferEventService{},
+		changes: &mock.BalanceChangeService{},
+		logger:  &wallet.NoopLogger{},
+	}
+}
+
+func TestAccountant_apply_SamePartition(t *testing.T) {
+	// Alice and Bob hash to the same partition, so both payments of the transfer
+	// (they share the request ID) are read by the same accountant.
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	amount := apd.New(50, -2)
+	payments := []*wallet.Payment{
+		{
+			ID:         wallet.PaymentID(requestID, "outgoing", "Alice"),
+			RequestID:  requestID,
+			Account:    "Alice",
+			Direction:  "outgoing",
+			Amount:     *amount,
+			SequenceID: 0,
+		},
+		{
+			ID:         wallet.PaymentID(requestID, "incoming", "Bob"),
+			RequestID:  requestID,
+			Account:    "Bob",
+			Direction:  "incoming",
+			Amount:     *amount,
+			SequenceID: 1,
+		},
+		// Duplicates are written when paymentd replays the transfer.
+		{
+			ID:         wallet.PaymentID(requestID, "outgoing", "Alice"),
+			RequestID:  requestID,
+			Account:    "Alice",
+			Direction:  "outgoing",
+			Amount:     *amount,
+			SequenceID: 2,
+		},
+		{
+			ID:         wallet.PaymentID(requestID, "incoming", "Bob"),
+			RequestID:  requestID,
+			Account:    "Bob",
+			Direction:  "incoming",
+			Amount:     *amount,
+			SequenceID: 3,
+		},
+	}
+
+	var rejections []wallet.Rejection
+	balances := map[string]apd.Decimal{
+		"Alice/USD": *apd.New(1, 0),
+	}
+	acc := newAccountant(balances, &rejections)
+
+	want := []outcome{outcomeApplied, outcomeApplied, outcomeDuplicate, outcomeDuplicate}
+	for i, p := range payments {
+		_, out, err := acc.apply(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != want[i] {
+			t.Errorf("payment %d outcome: %d, want %d", i, out, want[i])
+		}
+	}
+
+	wantBalances := map[string]string{
+		"Alice/USD": "0.50",
+		"Bob/USD":   "0.50",
+	}
+	for account, want := range wantBalances {
+		bal := balances[account]
+		if got := bal.Text('f'); got != want {
+			t.Errorf("%s balance: %s, want %s", account, got, want)
+		}
+	}
+}
+
+func TestAccountant_apply_InsufficientFunds(t *testing.T) {
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	amount := apd.New(50, -2)
+	payments := []*wallet.Payment{
+		{
+			ID:           wallet.PaymentID(requestID, "outgoing", "Alice"),
+			RequestID:    requestID,
+			Account:      "Alice",
+			Counterparty: "Bob",
+			Direction:    "outgoing",
+			Amount:       *amount,
+			SequenceID:   0,
+		},
+		// Bob's reversal arrived before his incoming payment, it must not be rejected.
+		{
+			ID:           wallet.PaymentID(requestID, "reversal", "Bob"),
+			RequestID:    requestID,
+			Account:      "Bob",
+			Counterparty: "Alice",
+			Direction:    "outgoing",
+			Amount:       *amount,
+			Reverses:     wallet.PaymentID(requestID, "incoming", "Bob"),
+			SequenceID:   1,
+		},
+		{
+			ID:           wallet.PaymentID(requestID, "incoming", "Bob"),
+			RequestID:    requestID,
+			Account:      "Bob",
+			Counterparty: "Alice",
+			Direction:    "incoming",
+			Amount:       *amount,
+			SequenceID:   2,
+		},
+		{
+			ID:           wallet.PaymentID(requestID, "outgoing", "Alice"),
+			RequestID:    requestID,
+			Account:      "Alice",
+			Counterparty: "Bob",
+			Direction:    "outgoing",
+			Amount:       *amount,
+			SequenceID:   3,
+		},
+	}
+
+	var rejections []wallet.Rejection
+	balances := make(map[string]apd.Decimal)
+	acc := newAccountant(balances, &rejections)
+
+	want := []outcome{outcomeRejected, outcomeApplied, outcomeApplied, outcomeDuplicate}
+	for i, p := range payments {
+		_, out, err := acc.apply(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != want[i] {
+			t.Errorf("payment %d outcome: %d, want %d", i, out, want[i])
+		}
+	}
+
+	if len(rejections) != 1 {
+		t.Fatalf("rejections: %d, want 1", len(rejections))
+	}
+	if rejections[0].Payment.ID != payments[0].ID {
+		t.Errorf("rejected payment: %s, want %s", rejections[0].Payment.ID, payments[0].ID)
+	}
+
+	wantBalances := map[string]string{
+		"Alice/USD": "0",
+		"Bob/USD":   "0.00",
+	}
+	for account, want := range wantBalances {
+		bal := balances[account]
+		if got := bal.Text('f'); got != want {
+			t.Errorf("%s balance: %s, want %s", account, got, want)
+		}
+	}
+}
+
+func TestAccountant_apply_Currencies(t *testing.T) {
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	payments := []*wallet.Payment{
+		// Alice can't afford to send EUR even though she has USD.
+		{
+			ID:         wallet.PaymentID(requestID, "outgoing", "Alice"),
+			RequestID:  requestID,
+			Account:    "Alice",
+			Direction:  "outgoing",
+			Amount:     *apd.New(50, -2),
+			Currency:   "EUR",
+			SequenceID: 0,
+		},
+		{
+			ID:         wallet.PaymentID("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "incoming", "Alice"),
+			RequestID:  "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			Account:    "Alice",
+			Direction:  "incoming",
+			Amount:     *apd.New(100, 0),
+			Currency:   "JPY",
+			SequenceID: 1,
+		},
+	}
+
+	var rejections []wallet.Rejection
+	balances := map[string]apd.Decimal{
+		"Alice/USD": *apd.New(1, 0),
+	}
+	acc := newAccountant(balances, &rejections)
+
+	want := []outcome{outcomeRejected, outcomeApplied}
+	for i, p := range payments {
+		_, out, err := acc.apply(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != want[i] {
+			t.Errorf("payment %d outcome: %d, want %d", i, out, want[i])
+		}
+	}
+
+	wantBalances := map[string]string{
+		"Alice/USD": "1",
+		"Alice/EUR": "0",
+		"Alice/JPY": "100",
+	}
+	for key, want := range wantBalances {
+		bal := balances[key]
+		if got := bal.Text('f'); got != want {
+			t.Errorf("%s balance: %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestAccountant_apply_BalanceChanges(t *testing.T) {
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	payments := []*wallet.Payment{
+		// Rejected payment doesn't change the balance, so nothing is published.
+		{
+			ID:         wallet.PaymentID(requestID, "outgoing", "Alice"),
+			RequestID:  requestID,
+			Account:    "Alice",
+			Direction:  "outgoing",
+			Amount:     *apd.New(5, 0),
+			Currency:   "USD",
+			Partition:  1,
+			SequenceID: 7,
+		},
+		{
+			ID:         wallet.PaymentID("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "incoming", "Alice"),
+			RequestID:  "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			Account:    "Alice",
+			Direction:  "incoming",
+			Amount:     *apd.New(100, 0),
+			Currency:   "JPY",
+			Partition:  1,
+			SequenceID: 8,
+		},
+	}
+
+	var (
+		rejections []wallet.Rejection
+		changes    []wallet.BalanceChange
+	)
+	balances := map[string]apd.Decimal{
+		"Alice/USD": *apd.New(1, 0),
+	}
+	acc := newAccountant(balances, &rejections)
+	acc.changes = &mock.BalanceChangeService{
+		CreateBalanceChangeFn: func(_ context.Context, b *wallet.BalanceChange) error {
+			changes = append(changes, *b)
+			return nil
+		},
+	}
+	for _, p := range payments {
+		if _, _, err := acc.apply(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("balance changes: %d, want 1", len(changes))
+	}
+	b := changes[0]
+	if b.Account != "Alice" || b.PaymentPartition != 1 || b.PaymentOffset != 8 {
+		t.Errorf("balance change: %s %d:%d, want Alice 1:8", b.Account, b.PaymentPartition, b.PaymentOffset)
+	}
+	// All of Alice's balances are published, not only the changed one.
+	want := []string{"JPY 100", "USD 1"}
+	if len(b.Balances) != len(want) {
+		t.Fatalf("balances: %v, want %v", b.Balances, want)
+	}
+	for i, bal := range b.Balances {
+		if got := bal.Currency + " " + bal.Amount.Text('f'); got != want[i] {
+			t.Errorf("balance %d: %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestAccountant_apply_EventFailed(t *testing.T) {
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	p := wallet.Payment{
+		ID:        wallet.PaymentID(requestID, "incoming", "Bob"),
+		RequestID: requestID,
+		Account:   "Bob",
+		Direction: "incoming",
+		Amount:    *apd.New(1, 0),
+		Currency:  "USD",
+	}
+
+	var rejections []wallet.Rejection
+	balances := map[string]apd.Decimal{}
+	acc := newAccountant(balances, &rejections)
+	// The event is emitted after the payment is applied, so it never describes a payment which wasn't saved.
+	acc.events = &mock.TransferEventService{
+		CreateEventFn: func(_ context.Context, e *wallet.TransferEvent) error {
+			bal := balances["Bob/USD"]
+			if bal.Cmp(apd.New(1, 0)) != 0 {
+				t.Errorf("event %s emitted before the payment was applied", e.Status)
+			}
+			return errors.New("broker unavailable")
+		},
+	}
+
+	_, out, err := acc.apply(context.Background(), &p)
+	if err == nil {
+		t.Fatal("expected event error")
+	}
+	if out != outcomeApplied {
+		t.Errorf("outcome: %d, want %d", out, outcomeApplied)
+	}
+	bal := balances["Bob/USD"]
+	if got := bal.Text('f'); got != "1" {
+		t.Errorf("Bob/USD balance: %s, want 1", got)
+	}
+}
+
+func TestAccountant_apply_BalanceChangeFailed(t *testing.T) {
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	p := wallet.Payment{
+		ID:        wallet.PaymentID(requestID, "incoming", "Bob"),
+		RequestID: requestID,
+		Account:   "Bob",
+		Direction: "incoming",
+		Amount:    *apd.New(1, 0),
+		Currency:  "USD",
+	}
+
+	var (
+		rejections []wallet.Rejection
+		changes    []wallet.BalanceChange
+	)
+	balances := map[string]apd.Decimal{}
+	acc := newAccountant(balances, &rejections)
+	broken := true
+	acc.changes = &mock.BalanceChangeService{
+		CreateBalanceChangeFn: func(_ context.Context, b *wallet.BalanceChange) error {
+			if broken {
+				return errors.New("broker unavailable")
+			}
+			changes = append(changes, *b)
+			return nil
+		},
+	}
+
+	// The payment isn't saved when its balance change isn't published, so it's published when the payment is retried.
+	if _, _, err := acc.apply(context.Background(), &p); err == nil {
+		t.Fatal("expected balance change error")
+	}
+	if bal := balances["Bob/USD"]; !bal.IsZero() {
+		t.Errorf("Bob/USD balance: %s, want 0", bal.Text('f'))
+	}
+
+	broken = false
+	if _, out, err := acc.apply(context.Background(), &p); err != nil || out != outcomeApplied {
+		t.Fatalf("apply() = %d, %v, want %d", out, err, outcomeApplied)
+	}
+	if len(changes) != 1 || changes[0].Balances[0].Amount.Text('f') != "1" {
+		t.Errorf("balance changes: %v, want Bob USD 1", changes)
+	}
+}
+
+func TestAccountant_apply_Pipeline(t *testing.T) {
+	c := memlog.NewClient(memlog.WithPartitions(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// paymentd creates the payments of the transfer requests, Alice can afford only the first one.
+	balances := map[string]apd.Decimal{"Alice/USD": *apd.New(1, 0)}
+	for _, id := range []string{"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "6ba7b810-9dad-11d1-80b4-00c04fd430c8"} {
+		tr := wallet.Transfer{ID: id, From: "Alice", To: "Bob", Amount: *apd.New(75, -2), Currency: "USD"}
+		if err := c.Transfer.CreateTransfer(ctx, &tr); err != nil {
+			t.Fatal(err)
+		}
+		err := c.Payment.(*memlog.PaymentService).CreateTransferPayments(ctx, &tr,
+			&wallet.Payment{ID: wallet.PaymentID(id, "outgoing", "Alice"), RequestID: id, Account: "Alice", Counterparty: "Bob", Direction: "outgoing", Amount: tr.Amount, Currency: "USD"},
+			&wallet.Payment{ID: wallet.PaymentID(id, "incoming", "Bob"), RequestID: id, Account: "Bob", Counterparty: "Alice", Direction: "incoming", Amount: tr.Amount, Currency: "USD"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rejections []wallet.Rejection
+	acc := newAccountant(balances, &rejections)
+	acc.rejections, acc.events, acc.changes = c.Rejection, c.Event, c.BalanceChange
+
+	payments, _ := c.Payment.FromOffset(ctx, 0, wallet.OffsetOldest)
+	want := []outcome{outcomeApplied, outcomeApplied, outcomeRejected, outcomeApplied}
+	for i, w := range want {
+		p := <-payments
+		if p == nil {
+			t.Fatalf("payment %d not found", i)
+		}
+		_, out, err := acc.apply(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != w {
+			t.Errorf("payment %s outcome: %d, want %d", p.ID, out, w)
+		}
+	}
+
+	for key, want := range map[string]string{"Alice/USD": "0.25", "Bob/USD": "1.50"} {
+		bal := balances[key]
+		if got := bal.Text('f'); got != want {
+			t.Errorf("%s balance: %s, want %s", key, got, want)
+		}
+	}
+
+	// paymentd finds the rejection in the partition of the transfer and reverses Bob's payment.
+	rr, _ := c.Rejection.FromOffset(ctx, 0, wallet.OffsetOldest)
+	if r := <-rr; r == nil || r.Payment.ID != wallet.PaymentID("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "outgoing", "Alice") {
+		t.Errorf("rejection: %+v", r)
+	}
+	events, _ := c.Event.FromOffset(ctx, 0, wallet.OffsetOldest)
+	for _, status := range []wallet.TransferStatus{wallet.StatusDebited, wallet.StatusCredited, wallet.StatusRejected, wallet.StatusCredited} {
+		if e := <-events; e == nil || e.Status != status {
+			t.Errorf("event: %+v, want %s", e, status)
+		}
+	}
+}
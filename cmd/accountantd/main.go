@@ -1,7 +1,20 @@
 // Command accountantd sequentially reads Kafka messages from wallet.payment topic,
-// deduplicates messages by request ID, and applies the changes to the account balances.
-// You can replay Kafka messages from any offset, as long as request IDs are persisted.
-// If the program crashes, it should recover dedup db based on Kafka topic ("source of truth").
+// deduplicates messages by payment ID, and applies the changes to the account balances.
+// Outgoing payments which would overdraw an account are rejected, rejections are emitted to
+// wallet.payment_rejection topic, so paymentd could reverse the recipient's payment.
+// Transfer status changes (debited, credited, rejected) are emitted to wallet.transfer_event topic.
+// Every balance change is published to log-compacted wallet.balance topic keyed by account,
+// so other services could read current balances, and a fresh program can restore them with -restore flag
+// instead of replaying all payments.
+// Balances and payment IDs are persisted in RocksDB, so the program keeps its state across restarts.
+// A payment ID, a new balance and an offset of the payment are saved atomically,
+// so after a restart the program resumes from the offset following the last applied payment.
+// You can replay Kafka messages from any offset or time using -offset or -since flag, as long as payment IDs are persisted.
+// If the program crashes, run it with -recover flag to repair dedup db based on Kafka topic ("source of truth").
+// Unless -partition flag is set, partitions are assigned by Kafka consumer group, so the program scales horizontally:
+// a partition's database is opened when the partition is assigned and closed when it's revoked.
+// With -backend=file messages are kept in a file log shared with transfer-server and paymentd on the same host,
+// all partitions are processed by one program unless -partition flag is set.
 package main
 
 import (
@@ -9,23 +22,43 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
-	"github.com/cockroachdb/apd"
 	"github.com/facebookgo/flagenv"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/filelog"
 	"github.com/marselester/distributed-payment/kafka"
 	"github.com/marselester/distributed-payment/rocks"
 )
 
 func main() {
-	broker := flag.String("broker", "127.0.0.1:9092", "Broker address to connect to.")
-	partition := flag.Int("partition", 0, "Partition number of wallet.payment topic.")
-	offset := flag.Int64("offset", -2, "Offset index of a partition (-1 to start from the newest, -2 from the oldest).")
+	backend := flag.String("backend", "kafka", "Where messages are kept: kafka or file.")
+	// Kafka connection flags, e.g., -broker, -tls, -acks.
+	connOptions := kafka.ConnFlags(flag.CommandLine)
+	// File log flags, e.g., -log-dir, -log-sync.
+	logOptions := filelog.Flags(flag.CommandLine)
+	partition := flag.Int("partition", -1, "Partition number of wallet.payment topic (-1 to get partitions assigned by -group or all partitions of the file log).")
+	group := flag.String("group", "accountantd", "Consumer group which commits processed offsets and gets partitions assigned.")
+	offset := flag.Int64("offset", -3, "Offset index of a partition (-1 to start from the newest, -2 from the oldest, -3 to resume from the last applied payment).")
+	since := flag.String("since", "", "Reprocess payments of -partition created at or after the time instead of -offset, e.g., 2019-03-01T14:05:00Z.")
+	dedupTTL := flag.Duration("dedup-ttl", 0, "How long payment IDs are kept, e.g., 168h to match wallet.payment topic retention (0 to keep forever).")
+	cacheSize := flag.Uint64("cache-size", rocks.DefaultCacheSize, "Size of RocksDB block cache in bytes, each partition's database has its own cache.")
+	overdraft := flag.String("overdraft", "", "Comma-separated accounts allowed to have a negative balance, e.g., Bank.")
+	recovery := flag.Bool("recover", false, "Repair payment IDs in RocksDB based on the partition and exit.")
+	recoverTimeout := flag.Duration("recover-timeout", time.Minute, "How long -recover scans the partition before it gives up.")
+	restore := flag.Bool("restore", false, "Restore balances from wallet.balance topic when a partition's RocksDB is empty.")
+	onError := flag.String("on-error", "stop", "What to do with a message which couldn't be processed: stop reading or skip it.")
+	deadLetter := flag.String("dead-letter", "", "Topic where Kafka messages which couldn't be processed are forwarded instead of -on-error policy, e.g., wallet.dead_letter.")
+	metricsAddr := flag.String("metrics", "", "Address of HTTP listener which serves Prometheus metrics at /metrics, e.g., 127.0.0.1:9102.")
 	debug := flag.Bool("debug", false, "Enable debug mode.")
 	// Parse env values.
 	flagenv.Parse()
@@ -40,24 +73,41 @@ func main() {
 		logger = &wallet.NoopLogger{}
 	}
 
-	c := kafka.NewClient(
-		kafka.WithBrokers(*broker),
-		kafka.WithLogger(logger),
-	)
-	if err := c.Open(); err != nil {
-		log.Fatalf("accountantd: failed to connect to Kafka: %v", err)
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr); err != nil {
+			log.Fatalf("accountantd: %v", err)
+		}
 	}
-	defer c.Close()
 
-	dbname := fmt.Sprintf("dedup%d.db", *partition)
-	dedup := rocks.NewDedupService(
-		rocks.WithDB(dbname),
-		rocks.WithLogger(logger),
-	)
-	if err := dedup.Open(); err != nil {
-		log.Fatalf("accountantd: failed to open RocksDB: %v", err)
+	var errorHandler kafka.ErrorHandler
+	switch *onError {
+	case "stop":
+		errorHandler = kafka.StopOnError
+	case "skip":
+		errorHandler = func(err *kafka.MessageError) error {
+			log.Printf("accountantd: skipped %v", err)
+			return nil
+		}
+	default:
+		log.Fatalf("accountantd: unknown -on-error policy %q", *onError)
+	}
+
+	// A consumer group claim starts at the group's committed offset, so the partition is read directly.
+	if *offset != wallet.OffsetCommitted && *partition < 0 {
+		log.Fatal("accountantd: -partition is required with -offset")
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		// A consumer group claim can't be rewound before the committed offset, so the partition is read directly.
+		if *partition < 0 {
+			log.Fatal("accountantd: -partition is required with -since")
+		}
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			log.Fatalf("accountantd: -since: %v", err)
+		}
+		sinceTime = t
 	}
-	defer dedup.Close()
 
 	// Listen to Ctrl+C and kill/killall to gracefully stop processing payments.
 	ctx, cancel := context.WithCancel(context.Background())
@@ -69,48 +119,340 @@ func main() {
 		cancel()
 	}()
 
-	balance := make(map[string]apd.Decimal)
-	payments, errc := c.Payment.FromOffset(ctx, int32(*partition), *offset)
-	for p := range payments {
-		// Deduplicate based on request ID, then update balance.
-		seen, err := dedup.HasSeen(p.RequestID)
+	d := daemon{
+		logger:         logger,
+		dedupTTL:       *dedupTTL,
+		cacheSize:      *cacheSize,
+		recoverTimeout: *recoverTimeout,
+		offset:         *offset,
+		since:          sinceTime,
+		restore:        *restore,
+		overdraft:      make(map[string]bool),
+	}
+	for _, account := range strings.Split(*overdraft, ",") {
+		if account = strings.TrimSpace(account); account != "" {
+			d.overdraft[account] = true
+		}
+	}
+
+	// consume processes the partitions of wallet.payment assigned to the program.
+	var (
+		consume    func(ctx context.Context, h func(ctx context.Context, partition int32) error) error
+		partitions []int32
+	)
+	switch *backend {
+	case "kafka":
+		opts, err := connOptions()
 		if err != nil {
-			log.Fatalf("accountantd: failed to read from RocksDB: %v", err)
+			log.Fatalf("accountantd: %v", err)
+		}
+		if *metricsAddr != "" {
+			// Consumer lag and throughput of the partitions are reported on the metrics listener.
+			opts = append(opts, kafka.WithMetrics(kafka.NewPrometheusMetrics()))
 		}
-		if seen {
-			logger.Log("level", "debug", "msg", "skip request", "request", p.RequestID)
-			continue
+		c := kafka.NewClient(append(opts,
+			kafka.WithGroup(*group),
+			kafka.WithErrorHandler(errorHandler),
+			kafka.WithDeadLetterTopic(*deadLetter),
+			kafka.WithLogger(logger),
+		)...)
+		if err := c.Open(); err != nil {
+			log.Fatalf("accountantd: failed to connect to Kafka: %v", err)
 		}
+		defer c.Close()
 
-		bal, err := calcBalance(balance[p.Account], p)
+		if partitions, err = c.Partitions(kafka.DefaultPaymentTopic); err != nil {
+			log.Fatalf("accountantd: failed to get partitions of %s: %v", kafka.DefaultPaymentTopic, err)
+		}
+		d.payments, d.rejections, d.events, d.changes = c.Payment, c.Rejection, c.Event, c.BalanceChange
+		d.offsetRange = func(partition int32) (int64, int64, error) {
+			return c.OffsetRange(kafka.DefaultPaymentTopic, partition)
+		}
+		d.committed = func(ctx context.Context, partition int32) (int64, error) {
+			return c.CommittedOffset(ctx, kafka.DefaultPaymentTopic, partition)
+		}
+		d.fail = c.Fail
+		consume = func(ctx context.Context, h func(ctx context.Context, partition int32) error) error {
+			return c.Consume(ctx, kafka.DefaultPaymentTopic, h)
+		}
+	case "file":
+		opts, err := logOptions()
 		if err != nil {
-			log.Fatalf("accountantd: failed to update balance: %v", err)
+			log.Fatalf("accountantd: %v", err)
+		}
+		c := filelog.NewClient(append(opts,
+			filelog.WithGroup(*group),
+			filelog.WithLogger(logger),
+		)...)
+		if err := c.Open(); err != nil {
+			log.Fatalf("accountantd: failed to open file log: %v", err)
+		}
+		defer c.Close()
+
+		partitions = c.Partitions()
+		d.payments, d.rejections, d.events, d.changes = c.Payment, c.Rejection, c.Event, c.BalanceChange
+		d.offsetRange = func(partition int32) (int64, int64, error) {
+			return c.OffsetRange(kafka.DefaultPaymentTopic, partition)
+		}
+		d.committed = func(ctx context.Context, partition int32) (int64, error) {
+			return c.CommittedOffset(kafka.DefaultPaymentTopic, partition)
 		}
-		balance[p.Account] = bal
-		fmt.Printf("%s balance: %s USD\n", p.Account, bal.Text('f'))
+		// The file log has no dead letter topic, so -on-error policy is applied.
+		d.fail = func(ctx context.Context, topic string, partition int32, offset int64, err error) error {
+			return errorHandler(&kafka.MessageError{Topic: topic, Partition: partition, Offset: offset, Err: err})
+		}
+		consume = func(ctx context.Context, h func(ctx context.Context, partition int32) error) error {
+			return c.Consume(ctx, h)
+		}
+	default:
+		log.Fatalf("accountantd: unknown backend %q", *backend)
+	}
+
+	// A partition which doesn't exist is rejected at startup before the partition's database is created.
+	if *partition >= len(partitions) {
+		log.Fatalf("accountantd: -partition=%d, but %s has %d partitions", *partition, kafka.DefaultPaymentTopic, len(partitions))
+	}
 
-		if err := dedup.Save(p.RequestID); err != nil {
-			log.Fatalf("accountantd: failed to save request ID: %v", err)
+	var err error
+	switch {
+	case *recovery:
+		if *partition < 0 {
+			log.Fatal("accountantd: -partition is required to recover")
 		}
+		err = d.recover(ctx, int32(*partition))
+	case *partition < 0:
+		err = consume(ctx, d.process)
+	default:
+		err = d.process(ctx, int32(*partition))
 	}
-	if err := <-errc; err != nil {
-		log.Printf("accountantd: payments fetch failed: %v", err)
+	if err != nil && err != context.Canceled {
+		log.Fatalf("accountantd: %v", err)
 	}
 }
 
-// calcBalance calculates account balance affected by a payment.
-func calcBalance(bal apd.Decimal, p *wallet.Payment) (apd.Decimal, error) {
-	dc := apd.BaseContext.WithPrecision(wallet.DecimalMaxDigits)
+// daemon holds settings shared by the partitions processed by accountantd.
+type daemon struct {
+	payments   wallet.PaymentService
+	rejections wallet.RejectionService
+	events     wallet.TransferEventService
+	changes    wallet.BalanceChangeService
+	// offsetRange returns the oldest and the newest offsets of the partition of payments.
+	offsetRange func(partition int32) (oldest, newest int64, err error)
+	// committed returns the group's offset following the last committed payment of the partition,
+	// or wallet.OffsetOldest when nothing was committed.
+	committed func(ctx context.Context, partition int32) (int64, error)
+	// fail decides whether a payment which couldn't be applied is skipped (nil is returned),
+	// e.g., it's forwarded to the dead letter topic, see kafka.Client.Fail.
+	fail      func(ctx context.Context, topic string, partition int32, offset int64, err error) error
+	logger    wallet.Logger
+	dedupTTL  time.Duration
+	cacheSize uint64
+	// recoverTimeout limits the scan of the partition when the payment IDs are repaired.
+	recoverTimeout time.Duration
+	offset         int64
+	// since is the creation time of the first payment to read instead of offset unless it's zero.
+	since time.Time
+	// restore fills an empty database with balances from the balance changes.
+	restore   bool
+	overdraft map[string]bool
+}
+
+// openStore opens RocksDB database of the partition.
+func (d *daemon) openStore(partition int32) (*rocks.Client, error) {
+	store := rocks.NewClient(
+		rocks.WithDB(fmt.Sprintf("dedup%d.db", partition)),
+		rocks.WithTTL(d.dedupTTL),
+		rocks.WithCacheSize(d.cacheSize),
+		rocks.WithLogger(d.logger),
+	)
+	if err := store.Open(); err != nil {
+		return nil, errors.Wrap(err, "failed to open RocksDB")
+	}
+	return store, nil
+}
 
-	if p.Direction == "outgoing" {
-		if res, err := dc.Sub(&bal, &bal, &p.Amount); err != nil {
-			return bal, errors.Wrapf(err, "incoming payment: %v", res)
+// recover repairs payment IDs in the partition's database.
+func (d *daemon) recover(ctx context.Context, partition int32) error {
+	store, err := d.openStore(partition)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	oldest, newest, err := d.offsetRange(partition)
+	if err != nil {
+		return errors.Wrap(err, "failed to get offsets of the partition")
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.recoverTimeout)
+	defer cancel()
+	r, err := store.Recover(ctx, d.payments, partition, oldest, newest)
+	if err != nil {
+		return errors.Wrap(err, "failed to recover RocksDB")
+	}
+	for _, id := range r.Missing {
+		fmt.Printf("restored missing payment %s\n", id)
+	}
+	for _, id := range r.Extra {
+		fmt.Printf("found extra payment %s\n", id)
+	}
+	return nil
+}
+
+// process applies payments of the partition until ctx is cancelled.
+// The partition's database is open while the payments are processed.
+func (d *daemon) process(ctx context.Context, partition int32) error {
+	store, err := d.openStore(partition)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if d.dedupTTL > 0 {
+		purged := make(chan struct{})
+		go func() {
+			defer close(purged)
+			purge(ctx, store, time.Hour)
+		}()
+		// Wait for a purge to finish before the database is closed.
+		defer func() {
+			cancel()
+			<-purged
+		}()
+	}
+
+	restored := 0
+	if d.restore {
+		if restored, err = store.Restore(ctx, d.changes, partition); err != nil {
+			return errors.Wrap(err, "failed to restore balances")
+		}
+		if restored > 0 {
+			fmt.Printf("restored balances of %d accounts of partition %d\n", restored, partition)
 		}
-		return bal, nil
 	}
 
-	if res, err := dc.Add(&bal, &bal, &p.Amount); err != nil {
-		return bal, errors.Wrapf(err, "outgoing payment: %v", res)
+	offset := d.offset
+	if offset == wallet.OffsetCommitted && d.since.IsZero() {
+		if offset, err = d.resumeOffset(ctx, store, partition, restored > 0); err != nil {
+			return err
+		}
+	}
+
+	acc := accountant{
+		dedup:      store.Dedup,
+		balance:    store.Balance,
+		ledger:     store.Ledger,
+		rejections: d.rejections,
+		events:     d.events,
+		changes:    d.changes,
+		logger:     d.logger,
+		overdraft:  d.overdraft,
+	}
+	var (
+		payments <-chan *wallet.Payment
+		errc     <-chan error
+	)
+	if d.since.IsZero() {
+		payments, errc = d.payments.FromOffset(ctx, partition, offset)
+	} else {
+		// Payments which were already applied are skipped by payment ID.
+		payments, errc = d.payments.FromTime(ctx, partition, d.since)
+	}
+	for p := range payments {
+		bal, out, err := acc.apply(ctx, p)
+		if err != nil {
+			// The payment is skipped unless the error policy stops reading.
+			if err = d.fail(ctx, kafka.DefaultPaymentTopic, partition, p.SequenceID, errors.Wrap(err, "failed to apply payment")); err != nil {
+				// Stop reading payments, so the partition's database is closed gracefully.
+				cancel()
+				<-errc
+				return err
+			}
+			out = outcomeSkipped
+		}
+		// The payment didn't change a balance, but the stored offset moves past it along with the committed one,
+		// otherwise the program would resume before the group's committed offset.
+		if out == outcomeDuplicate || out == outcomeSkipped {
+			if err = store.Ledger.SetOffset(p.SequenceID); err != nil {
+				cancel()
+				<-errc
+				return errors.Wrap(err, "failed to save offset")
+			}
+		}
+		switch out {
+		case outcomeApplied:
+			fmt.Printf("%s balance: %s %s\n", p.Account, bal.Text('f'), p.Currency)
+		case outcomeRejected:
+			fmt.Printf("%s payment %s rejected: %s, balance: %s %s\n", p.Account, p.ID, wallet.ErrInsufficientFunds, bal.Text('f'), p.Currency)
+		}
+		// The committed offset lets the group's next claim of the partition start close to the stored one.
+		if err = d.payments.CommitOffset(ctx, partition, p.SequenceID); err != nil {
+			cancel()
+			<-errc
+			return errors.Wrap(err, "failed to commit offset")
+		}
+	}
+	if err := <-errc; err != nil && err != context.Canceled {
+		return errors.Wrap(err, "payments fetch failed")
+	}
+	return nil
+}
+
+// resumeOffset returns the offset following the last payment recorded in the partition's database.
+// The stored offset is preferred to the one committed by the group, because it's saved along with the balance.
+// Restored balances end at the last payment which changed a balance, and the payments following it
+// up to the committed offset didn't change balances, so the committed offset is used if it's later.
+// An empty database is an error when the group has committed payments already,
+// because the balances would start from zero.
+func (d *daemon) resumeOffset(ctx context.Context, store *rocks.Client, partition int32, restored bool) (int64, error) {
+	committed, err := d.committed(ctx, partition)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read committed offset")
+	}
+
+	lastOffset, err := store.Ledger.Offset()
+	switch {
+	case err == wallet.ErrOffsetNotFound && committed > 0:
+		return 0, errors.Errorf("database of partition %d is empty, but the group resumes at offset %d: restore balances with -restore or replay the partition with -offset", partition, committed)
+	case err == wallet.ErrOffsetNotFound:
+		return wallet.OffsetCommitted, nil
+	case err != nil:
+		return 0, errors.Wrap(err, "failed to read offset")
+	}
+
+	offset := lastOffset + 1
+	if restored && committed > offset {
+		offset = committed
+	}
+	d.logger.Log("level", "debug", "msg", "resume from stored offset", "partition", partition, "offset", offset, "committed", committed)
+	return offset, nil
+}
+
+// purge periodically removes expired payment IDs until ctx is cancelled.
+// RocksDB compacts records only when enough data is written, so expired IDs could stay for a long time.
+func purge(ctx context.Context, store *rocks.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		store.Purge()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serveMetrics serves Prometheus metrics at /metrics in background, e.g., consumer lag of the partitions.
+func serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for metrics")
 	}
-	return bal, nil
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.Serve(ln, mux)
+	return nil
 }
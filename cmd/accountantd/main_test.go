@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/rocks"
+)
+
+func TestDaemon_resumeOffset(t *testing.T) {
+	const noOffset = -1
+	tests := map[string]struct {
+		// ledger is the offset stored in the database.
+		ledger    int64
+		committed int64
+		restored  bool
+		want      int64
+		wantErr   bool
+	}{
+		"new group":              {noOffset, wallet.OffsetOldest, false, wallet.OffsetCommitted, false},
+		"empty database":         {noOffset, 5, false, 0, true},
+		"resume":                 {7, 8, false, 8, false},
+		"committed behind":       {7, 3, false, 8, false},
+		"database behind":        {4, 9, false, 5, false},
+		"restored":               {4, 9, true, 9, false},
+		"restored ahead":         {4, 2, true, 5, false},
+		"restored without group": {4, wallet.OffsetOldest, true, 5, false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := rocks.NewClient(rocks.WithDB(filepath.Join(t.TempDir(), "dedup0.db")))
+			if err := store.Open(); err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if tc.ledger != noOffset {
+				p := wallet.Payment{ID: "1:outgoing:Alice", Account: "Alice", Currency: "USD", SequenceID: tc.ledger}
+				if err := store.Ledger.ApplyPayment(&p, *apd.New(1, 0)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := daemon{
+				logger: &wallet.NoopLogger{},
+				committed: func(context.Context, int32) (int64, error) {
+					return tc.committed, nil
+				},
+			}
+			got, err := d.resumeOffset(context.Background(), store, 0, tc.restored)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("resumeOffset() error: %v, want error %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("resumeOffset() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
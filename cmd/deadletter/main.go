@@ -0,0 +1,125 @@
+// Command deadletter inspects messages which couldn't be processed by paymentd or accountantd
+// and were forwarded to wallet.dead_letter topic. Once a message is fixed, it can be re-injected
+// into its original topic, e.g., a malformed payment is sent back to wallet.payment with the same key.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/facebookgo/flagenv"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/kafka"
+)
+
+func main() {
+	// Kafka connection flags, e.g., -broker, -tls, -acks.
+	connOptions := kafka.ConnFlags(flag.CommandLine)
+	topic := flag.String("topic", kafka.DefaultDeadLetterTopic, "Dead letter topic.")
+	partition := flag.Int("partition", 0, "Partition number of the dead letter topic.")
+	offset := flag.Int64("offset", -2, "Offset index of a partition to start inspecting from (-2 from the oldest).")
+	reinject := flag.Int64("reinject", -1, "Offset of a dead letter to re-inject into its original topic.")
+	valueFile := flag.String("value", "", "File with a fixed message value to re-inject instead of the original one.")
+	debug := flag.Bool("debug", false, "Enable debug mode.")
+	// Parse env values.
+	flagenv.Parse()
+	// Override env values with command line flag values.
+	flag.Parse()
+
+	var logger wallet.Logger
+	if *debug {
+		w := kitlog.NewSyncWriter(os.Stderr)
+		logger = kitlog.NewLogfmtLogger(w)
+	} else {
+		logger = &wallet.NoopLogger{}
+	}
+
+	opts, err := connOptions()
+	if err != nil {
+		log.Fatalf("deadletter: %v", err)
+	}
+	c := kafka.NewClient(append(opts,
+		kafka.WithDeadLetterTopic(*topic),
+		kafka.WithLogger(logger),
+	)...)
+	if err := c.Open(); err != nil {
+		log.Fatalf("deadletter: failed to connect to Kafka: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt)
+	go func() {
+		<-sigchan
+		cancel()
+	}()
+
+	oldest, newest, err := c.OffsetRange(*topic, int32(*partition))
+	if err != nil {
+		log.Fatalf("deadletter: failed to get offsets: %v", err)
+	}
+	start := *offset
+	if *reinject >= 0 {
+		start = *reinject
+	}
+	if start < 0 {
+		start = oldest
+	}
+	if start >= newest {
+		fmt.Println("no dead letters")
+		return
+	}
+
+	letters, errc := c.DeadLetters(ctx, int32(*partition), start)
+	for l := range letters {
+		if *reinject < 0 {
+			fmt.Printf("%d:%d %s/%d@%d %s\n%s\n", l.DeadLetterPartition, l.SequenceID, l.Topic, l.Partition, l.Offset, l.Err, l.Value)
+		} else {
+			if err = reinjectLetter(ctx, c, l, *valueFile); err != nil {
+				log.Fatalf("deadletter: %v", err)
+			}
+			cancel()
+		}
+		// The whole partition has been read.
+		if l.SequenceID+1 >= newest {
+			cancel()
+		}
+	}
+	if err := <-errc; err != nil && err != context.Canceled {
+		log.Printf("deadletter: dead letters fetch failed: %v", err)
+	}
+}
+
+// reinjector sends dead letters back to their original topics, see kafka.Client.
+type reinjector interface {
+	Reinject(ctx context.Context, l *kafka.DeadLetter) (int32, int64, error)
+}
+
+// reinjectLetter sends the dead letter back to its original topic.
+// When the file name is given, the message value is replaced with the file content.
+func reinjectLetter(ctx context.Context, c reinjector, l *kafka.DeadLetter, filename string) error {
+	if filename != "" {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		l.Value = b
+	}
+
+	partition, offset, err := c.Reinject(ctx, l)
+	if err != nil {
+		return errors.Wrapf(err, "re-inject %d:%d", l.DeadLetterPartition, l.SequenceID)
+	}
+	fmt.Printf("%d:%d re-injected %s/%d@%d as %s/%d@%d\n", l.DeadLetterPartition, l.SequenceID, l.Topic, l.Partition, l.Offset, l.Topic, partition, offset)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/marselester/distributed-payment/kafka"
+)
+
+// reinjectFunc lets a func be used as a reinjector.
+type reinjectFunc func(ctx context.Context, l *kafka.DeadLetter) (int32, int64, error)
+
+func (f reinjectFunc) Reinject(ctx context.Context, l *kafka.DeadLetter) (int32, int64, error) {
+	return f(ctx, l)
+}
+
+func TestReinjectLetter(t *testing.T) {
+	fixed := filepath.Join(t.TempDir(), "payment.json")
+	if err := ioutil.WriteFile(fixed, []byte(`{"account":"Alice"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		filename string
+		want     string
+	}{
+		"original value": {"", `{"account":"Alice"`},
+		"fixed value":    {fixed, `{"account":"Alice"}`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			l := kafka.DeadLetter{
+				Topic:     kafka.DefaultPaymentTopic,
+				Partition: 1,
+				Offset:    7,
+				Key:       []byte("Alice"),
+				Value:     []byte(`{"account":"Alice"`),
+			}
+			var got *kafka.DeadLetter
+			r := reinjectFunc(func(_ context.Context, l *kafka.DeadLetter) (int32, int64, error) {
+				got = l
+				return 1, 8, nil
+			})
+			if err := reinjectLetter(context.Background(), r, &l, tc.filename); err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || got.Topic != kafka.DefaultPaymentTopic || string(got.Key) != "Alice" {
+				t.Fatalf("reinjected: %+v", got)
+			}
+			if string(got.Value) != tc.want {
+				t.Errorf("value: %s, want %s", got.Value, tc.want)
+			}
+		})
+	}
+}
+
+func TestReinjectLetter_errors(t *testing.T) {
+	l := kafka.DeadLetter{Topic: kafka.DefaultPaymentTopic, DeadLetterPartition: 0, SequenceID: 3}
+	called := false
+	r := reinjectFunc(func(context.Context, *kafka.DeadLetter) (int32, int64, error) {
+		called = true
+		return 0, 0, errors.New("leader not available")
+	})
+
+	// The message isn't sent when the fixed value can't be read.
+	if err := reinjectLetter(context.Background(), r, &l, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected file error")
+	}
+	if called {
+		t.Fatal("dead letter re-injected without the fixed value")
+	}
+
+	err := reinjectLetter(context.Background(), r, &l, "")
+	if err == nil || err.Error() != "re-inject 0:3: leader not available" {
+		t.Fatalf("error: %v", err)
+	}
+}
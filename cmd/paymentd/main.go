@@ -1,5 +1,16 @@
 // Command paymentd is responsible to create incoming & outgoing payment pair based on money transfer request.
-// You can replay Kafka messages from any offset, duplicates are skipped by the next process in the pipeline.
+// Each payment gets an ID derived from the request ID, direction and account.
+// The payments and the offset of the transfer request are written in one Kafka transaction,
+// so a crash can't leave a debit without a credit. The transfer is reported as accepted to wallet.transfer_event topic.
+// It also reads payment rejections from wallet.payment_rejection partition with the same number and
+// reverses the recipient's payment, so a transfer that would overdraw the sender is rejected as a whole.
+// Offsets of rejections are committed to Kafka by a separate consumer group (-rejection-group flag),
+// so after a restart the program resumes where it stopped.
+// You can replay Kafka messages from any offset or time (-since flag), duplicates are skipped by the next process in the pipeline.
+// Unless -partition flag is set, partitions of wallet.transfer_request are assigned by Kafka consumer group,
+// so the program scales horizontally.
+// With -backend=file messages are kept in a file log shared with transfer-server and accountantd on the same host,
+// all partitions are processed by one program unless -partition flag is set.
 package main
 
 import (
@@ -7,20 +18,39 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/facebookgo/flagenv"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/filelog"
 	"github.com/marselester/distributed-payment/kafka"
 )
 
 func main() {
-	broker := flag.String("broker", "127.0.0.1:9092", "Broker address to connect to.")
-	partition := flag.Int("partition", 0, "Partition number of wallet.transfer_request topic.")
-	offset := flag.Int64("offset", -2, "Offset index of a partition (-1 to start from the newest, -2 from the oldest).")
+	backend := flag.String("backend", "kafka", "Where messages are kept: kafka or file.")
+	// Kafka connection flags, e.g., -broker, -tls, -acks.
+	connOptions := kafka.ConnFlags(flag.CommandLine)
+	// File log flags, e.g., -log-dir, -log-sync.
+	logOptions := filelog.Flags(flag.CommandLine)
+	partition := flag.Int("partition", -1, "Partition number of wallet.transfer_request topic (-1 to get partitions assigned by -group or all partitions of the file log).")
+	group := flag.String("group", "paymentd", "Consumer group which commits processed offsets and gets partitions assigned.")
+	rejectionGroup := flag.String("rejection-group", "paymentd-rejection", "Consumer group which commits offsets of processed rejections, it must differ from -group.")
+	offset := flag.Int64("offset", -3, "Offset index of a partition (-1 to start from the newest, -2 from the oldest, -3 to resume from the committed offset).")
+	rejectionOffset := flag.Int64("rejection-offset", -3, "Offset index of wallet.payment_rejection partition with the same number.")
+	since := flag.String("since", "", "Reprocess transfer requests of -partition created at or after the time instead of -offset, e.g., 2019-03-01T14:05:00Z.")
+	transactionalID := flag.String("transactional-id", defaultTransactionalID(), "Transactional ID of the payments producer, it must be unique per paymentd instance.")
+	onError := flag.String("on-error", "stop", "What to do with a message which couldn't be processed: stop reading or skip it.")
+	deadLetter := flag.String("dead-letter", "", "Topic where Kafka messages which couldn't be processed are forwarded instead of -on-error policy, e.g., wallet.dead_letter.")
+	codecName := flag.String("codec", "json", "How payments are encoded in Kafka: json, protobuf or avro.")
+	metricsAddr := flag.String("metrics", "", "Address of HTTP listener which serves Prometheus metrics at /metrics, e.g., 127.0.0.1:9101.")
 	debug := flag.Bool("debug", false, "Enable debug mode.")
 	// Parse env values.
 	flagenv.Parse()
@@ -35,14 +65,44 @@ func main() {
 		logger = &wallet.NoopLogger{}
 	}
 
-	c := kafka.NewClient(
-		kafka.WithBrokers(*broker),
-		kafka.WithLogger(logger),
-	)
-	if err := c.Open(); err != nil {
-		log.Fatalf("paymentd: failed to connect to Kafka: %v", err)
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr); err != nil {
+			log.Fatalf("paymentd: %v", err)
+		}
+	}
+
+	var errorHandler kafka.ErrorHandler
+	switch *onError {
+	case "stop":
+		errorHandler = kafka.StopOnError
+	case "skip":
+		errorHandler = func(err *kafka.MessageError) error {
+			log.Printf("paymentd: skipped %v", err)
+			return nil
+		}
+	default:
+		log.Fatalf("paymentd: unknown -on-error policy %q", *onError)
+	}
+
+	// A consumer group claim starts at the group's committed offset, so the partition is read directly.
+	if (*offset != wallet.OffsetCommitted || *rejectionOffset != wallet.OffsetCommitted) && *partition < 0 {
+		log.Fatal("paymentd: -partition is required with -offset and -rejection-offset")
+	}
+	if *rejectionGroup == *group {
+		log.Fatal("paymentd: -rejection-group must differ from -group")
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		// A consumer group claim can't be rewound before the committed offset, so the partition is read directly.
+		if *partition < 0 {
+			log.Fatal("paymentd: -partition is required with -since")
+		}
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			log.Fatalf("paymentd: -since: %v", err)
+		}
+		sinceTime = t
 	}
-	defer c.Close()
 
 	// Listen to Ctrl+C and kill/killall to gracefully stop processing transfer requests.
 	ctx, cancel := context.WithCancel(context.Background())
@@ -54,31 +114,296 @@ func main() {
 		cancel()
 	}()
 
-	transfers, errc := c.Transfer.FromOffset(ctx, int32(*partition), *offset)
-	for t := range transfers {
-		outPay := wallet.Payment{
-			RequestID: t.ID,
-			Account:   t.From,
-			Direction: "outgoing",
-			Amount:    t.Amount,
+	d := daemon{
+		offset:          *offset,
+		rejectionOffset: *rejectionOffset,
+		since:           sinceTime,
+	}
+	// consume processes the partitions of wallet.transfer_request assigned to the program.
+	var (
+		consume    func(ctx context.Context, h func(ctx context.Context, partition int32) error) error
+		partitions []int32
+	)
+	switch *backend {
+	case "kafka":
+		codec, err := kafka.CodecByName(*codecName)
+		if err != nil {
+			log.Fatalf("paymentd: %v", err)
+		}
+
+		opts, err := connOptions()
+		if err != nil {
+			log.Fatalf("paymentd: %v", err)
+		}
+		if *metricsAddr != "" {
+			// Consumer lag and throughput of the partitions are reported on the metrics listener.
+			opts = append(opts, kafka.WithMetrics(kafka.NewPrometheusMetrics()))
+		}
+		c := kafka.NewClient(append(opts,
+			kafka.WithCodec(codec),
+			kafka.WithGroup(*group),
+			kafka.WithTransactionalID(*transactionalID),
+			kafka.WithErrorHandler(errorHandler),
+			kafka.WithDeadLetterTopic(*deadLetter),
+			kafka.WithLogger(logger),
+		)...)
+		if err := c.Open(); err != nil {
+			log.Fatalf("paymentd: failed to connect to Kafka: %v", err)
+		}
+		defer c.Close()
+		// Rejections aren't claimed by -group, and the broker rejects offsets committed on behalf of a group
+		// without joining it, so they are committed by a group of their own.
+		rc := kafka.NewClient(append(opts,
+			kafka.WithGroup(*rejectionGroup),
+			kafka.WithErrorHandler(errorHandler),
+			kafka.WithDeadLetterTopic(*deadLetter),
+			kafka.WithLogger(logger),
+		)...)
+		if err := rc.Open(); err != nil {
+			log.Fatalf("paymentd: failed to connect to Kafka: %v", err)
+		}
+		defer rc.Close()
+
+		if partitions, err = c.Partitions(kafka.DefaultTransferTopic); err != nil {
+			log.Fatalf("paymentd: failed to get partitions of %s: %v", kafka.DefaultTransferTopic, err)
 		}
-		if err := c.Payment.CreatePayment(ctx, &outPay); err != nil {
-			log.Fatalf("paymentd: create outgoing payment: %v", err)
+		d.transfers, d.payments, d.rejections, d.events = c.Transfer, c.Payment, rc.Rejection, c.Event
+		d.payer = c.Payment.(*kafka.PaymentService)
+		d.fail = c.Fail
+		consume = func(ctx context.Context, h func(ctx context.Context, partition int32) error) error {
+			return c.Consume(ctx, kafka.DefaultTransferTopic, h)
 		}
-		fmt.Printf("%d:%d %s %s -$%s\n", outPay.Partition, outPay.SequenceID, outPay.RequestID, outPay.Account, outPay.Amount.Text('f'))
+	case "file":
+		opts, err := logOptions()
+		if err != nil {
+			log.Fatalf("paymentd: %v", err)
+		}
+		c := filelog.NewClient(append(opts,
+			filelog.WithGroup(*group),
+			filelog.WithLogger(logger),
+		)...)
+		if err := c.Open(); err != nil {
+			log.Fatalf("paymentd: failed to open file log: %v", err)
+		}
+		defer c.Close()
 
-		inPay := wallet.Payment{
-			RequestID: t.ID,
-			Account:   t.To,
-			Direction: "incoming",
-			Amount:    t.Amount,
+		partitions = c.Partitions()
+		d.transfers, d.payments, d.rejections, d.events = c.Transfer, c.Payment, c.Rejection, c.Event
+		d.payer = c.Payment.(*filelog.PaymentService)
+		// The file log has no dead letter topic, so -on-error policy is applied.
+		d.fail = func(ctx context.Context, topic string, partition int32, offset int64, err error) error {
+			return errorHandler(&kafka.MessageError{Topic: topic, Partition: partition, Offset: offset, Err: err})
 		}
-		if err := c.Payment.CreatePayment(ctx, &inPay); err != nil {
-			log.Fatalf("paymentd: create incoming payment: %v", err)
+		consume = func(ctx context.Context, h func(ctx context.Context, partition int32) error) error {
+			return c.Consume(ctx, h)
 		}
-		fmt.Printf("%d:%d %s %s +$%s\n", inPay.Partition, inPay.SequenceID, inPay.RequestID, inPay.Account, inPay.Amount.Text('f'))
+	default:
+		log.Fatalf("paymentd: unknown backend %q", *backend)
+	}
+
+	// -partition must be one of the topic's partitions, e.g., 0 or 1 when there are two.
+	if *partition >= len(partitions) {
+		log.Fatalf("paymentd: -partition=%d, but %s has %d partitions", *partition, kafka.DefaultTransferTopic, len(partitions))
 	}
-	if err := <-errc; err != nil {
-		log.Printf("paymentd: transfers fetch failed: %v", err)
+
+	var err error
+	if *partition < 0 {
+		err = consume(ctx, d.process)
+	} else {
+		err = d.process(ctx, int32(*partition))
+	}
+	if err != nil && err != context.Canceled {
+		log.Fatalf("paymentd: %v", err)
+	}
+}
+
+// daemon holds settings shared by the partitions processed by paymentd.
+type daemon struct {
+	transfers  wallet.TransferService
+	payments   wallet.PaymentService
+	rejections wallet.RejectionService
+	events     wallet.TransferEventService
+	// payer creates payments of a transfer along with committing the transfer's offset,
+	// it's provided by Kafka and file log payment services.
+	payer transferPayer
+	// fail decides whether a message which couldn't be processed is skipped (nil is returned),
+	// e.g., it's forwarded to the dead letter topic, see kafka.Client.Fail.
+	fail            func(ctx context.Context, topic string, partition int32, offset int64, err error) error
+	offset          int64
+	rejectionOffset int64
+	// since is the creation time of the first transfer to read instead of offset unless it's zero.
+	since time.Time
+}
+
+// process creates payments of the partition's transfer requests and reverses the rejected ones until ctx is cancelled.
+// Rejections are read from wallet.payment_rejection partition with the same number.
+// Offsets of transfers are committed along with their payments, offsets of rejections are committed once they're processed.
+func (d *daemon) process(ctx context.Context, partition int32) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		transfers <-chan *wallet.Transfer
+		errc      <-chan error
+	)
+	if d.since.IsZero() {
+		transfers, errc = d.transfers.FromOffset(ctx, partition, d.offset)
+	} else {
+		transfers, errc = d.transfers.FromTime(ctx, partition, d.since)
+	}
+	rejections, rejErrc := d.rejections.FromOffset(ctx, partition, d.rejectionOffset)
+	// stop stops reading transfers and rejections when one of them couldn't be processed.
+	stop := func(err error) error {
+		cancel()
+		<-errc
+		<-rejErrc
+		return err
+	}
+	for transfers != nil || rejections != nil {
+		select {
+		case t, ok := <-transfers:
+			if !ok {
+				// Stop reading rejections as well, since transfers can't be processed anymore.
+				transfers = nil
+				cancel()
+				continue
+			}
+			// The payments are traced back to the HTTP request which created the transfer.
+			tctx := wallet.WithTraceID(ctx, t.Meta.TraceID)
+			// The event is emitted before the payments, because the transfer request isn't read again
+			// once its offset is committed. A duplicate event is harmless when the request is retried.
+			e := wallet.TransferEvent{
+				RequestID: t.ID,
+				Status:    wallet.StatusAccepted,
+				Time:      time.Now().UTC(),
+				Transfer:  t,
+			}
+			err := d.events.CreateEvent(tctx, &e)
+			if err != nil {
+				err = errors.Wrap(err, "create transfer event")
+			} else {
+				err = createPayments(tctx, d.payer, t)
+			}
+			if err == nil {
+				continue
+			}
+			if err = d.fail(ctx, kafka.DefaultTransferTopic, t.Partition, t.SequenceID, err); err != nil {
+				return stop(err)
+			}
+			// The transfer is skipped, its payments weren't created.
+			if err = d.transfers.CommitOffset(ctx, t.Partition, t.SequenceID); err != nil {
+				return stop(errors.Wrap(err, "commit transfer offset"))
+			}
+		case r, ok := <-rejections:
+			if !ok {
+				rejections = nil
+				cancel()
+				continue
+			}
+			if err := reversePayment(ctx, d.payments, r); err != nil {
+				if err = d.fail(ctx, kafka.DefaultRejectionTopic, r.Partition, r.SequenceID, err); err != nil {
+					return stop(err)
+				}
+			}
+			if err := d.rejections.CommitOffset(ctx, r.Partition, r.SequenceID); err != nil {
+				return stop(errors.Wrap(err, "commit rejection offset"))
+			}
+		}
+	}
+	if err := <-errc; err != nil && err != context.Canceled {
+		return errors.Wrap(err, "transfers fetch failed")
+	}
+	if err := <-rejErrc; err != nil && err != context.Canceled {
+		return errors.Wrap(err, "rejections fetch failed")
+	}
+	return nil
+}
+
+// transferPayer creates payments of a transfer request and commits the request's offset,
+// see kafka.PaymentService and filelog.PaymentService.
+type transferPayer interface {
+	CreateTransferPayments(ctx context.Context, t *wallet.Transfer, payments ...*wallet.Payment) error
+}
+
+// createPayments creates outgoing and incoming payments of the transfer in the transfer's currency.
+// Both payments are written along with the transfer's offset, with Kafka either all of them are persisted or none.
+func createPayments(ctx context.Context, tp transferPayer, t *wallet.Transfer) error {
+	// Transfers created before currencies were introduced don't have them.
+	if t.Currency == "" {
+		t.Currency = wallet.DefaultCurrency
+	}
+
+	outPay := wallet.Payment{
+		ID:           wallet.PaymentID(t.ID, "outgoing", t.From),
+		RequestID:    t.ID,
+		Account:      t.From,
+		Counterparty: t.To,
+		Direction:    "outgoing",
+		Amount:       t.Amount,
+		Currency:     t.Currency,
+	}
+	inPay := wallet.Payment{
+		ID:           wallet.PaymentID(t.ID, "incoming", t.To),
+		RequestID:    t.ID,
+		Account:      t.To,
+		Counterparty: t.From,
+		Direction:    "incoming",
+		Amount:       t.Amount,
+		Currency:     t.Currency,
+	}
+	if err := tp.CreateTransferPayments(ctx, t, &outPay, &inPay); err != nil {
+		return errors.Wrap(err, "create payments")
+	}
+	fmt.Printf("%d:%d %s %s -%s %s\n", outPay.Partition, outPay.SequenceID, outPay.RequestID, outPay.Account, outPay.Amount.Text('f'), outPay.Currency)
+	fmt.Printf("%d:%d %s %s +%s %s\n", inPay.Partition, inPay.SequenceID, inPay.RequestID, inPay.Account, inPay.Amount.Text('f'), inPay.Currency)
+	return nil
+}
+
+// reversePayment compensates the recipient's incoming payment when the sender's outgoing payment was rejected,
+// so the transfer is rejected as a whole. For example, Bob is charged back $0.5 when Alice
+// couldn't afford to send him the money.
+func reversePayment(ctx context.Context, ps wallet.PaymentService, r *wallet.Rejection) error {
+	rejected := r.Payment
+	if rejected.Direction != "outgoing" || rejected.Counterparty == "" {
+		fmt.Printf("skip rejection %d:%d of payment %s\n", r.Partition, r.SequenceID, rejected.ID)
+		return nil
+	}
+
+	revPay := wallet.Payment{
+		ID:           wallet.PaymentID(rejected.RequestID, "reversal", rejected.Counterparty),
+		RequestID:    rejected.RequestID,
+		Account:      rejected.Counterparty,
+		Counterparty: rejected.Account,
+		Direction:    "outgoing",
+		Amount:       rejected.Amount,
+		Currency:     rejected.Currency,
+		Reverses:     wallet.PaymentID(rejected.RequestID, "incoming", rejected.Counterparty),
+	}
+	if err := ps.CreatePayment(ctx, &revPay); err != nil {
+		return errors.Wrap(err, "create reversal payment")
+	}
+	fmt.Printf("%d:%d %s %s -%s %s reversal\n", revPay.Partition, revPay.SequenceID, revPay.RequestID, revPay.Account, revPay.Amount.Text('f'), revPay.Currency)
+	return nil
+}
+
+// defaultTransactionalID returns a transactional ID based on the host name,
+// so the same paymentd instance keeps the ID after a restart.
+func defaultTransactionalID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "paymentd"
+	}
+	return "paymentd-" + host
+}
+
+// serveMetrics serves Prometheus metrics at /metrics in background, e.g., consumer lag of the partitions.
+func serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for metrics")
 	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.Serve(ln, mux)
+	return nil
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/kafka"
+	"github.com/marselester/distributed-payment/memlog"
+)
+
+const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+
+// newDaemon returns a daemon which reads the transfers and rejections of the in-memory log from the oldest offset.
+func newDaemon(c *memlog.Client) *daemon {
+	return &daemon{
+		transfers:       c.Transfer,
+		payments:        c.Payment,
+		rejections:      c.Rejection,
+		events:          c.Event,
+		payer:           c.Payment.(*memlog.PaymentService),
+		fail:            func(context.Context, string, int32, int64, error) error { return nil },
+		offset:          wallet.OffsetOldest,
+		rejectionOffset: wallet.OffsetOldest,
+	}
+}
+
+func TestDaemon_process(t *testing.T) {
+	c := memlog.NewClient(memlog.WithPartitions(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tr := wallet.Transfer{ID: requestID, From: "Alice", To: "Bob", Amount: *apd.New(5, -1), Currency: "USD"}
+	if err := c.Transfer.CreateTransfer(ctx, &tr); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDaemon(c)
+	errc := make(chan error, 1)
+	go func() { errc <- d.process(ctx, 0) }()
+
+	payments, _ := c.Payment.FromOffset(ctx, 0, wallet.OffsetOldest)
+	want := []string{
+		wallet.PaymentID(requestID, "outgoing", "Alice"),
+		wallet.PaymentID(requestID, "incoming", "Bob"),
+	}
+	var outPay *wallet.Payment
+	for i, id := range want {
+		p := <-payments
+		if p == nil {
+			t.Fatalf("payment %d not created", i)
+		}
+		if p.ID != id || p.Amount.Text('f') != "0.5" || p.Currency != "USD" {
+			t.Errorf("payment %d: %s %s %s, want %s 0.5 USD", i, p.ID, p.Amount.Text('f'), p.Currency, id)
+		}
+		if i == 0 {
+			outPay = p
+		}
+	}
+
+	// Alice can't afford the transfer, so accountantd rejects her payment and Bob's payment is reversed.
+	r := wallet.Rejection{Payment: *outPay, Reason: wallet.ErrInsufficientFunds.Error()}
+	if err := c.Rejection.CreateRejection(ctx, &r); err != nil {
+		t.Fatal(err)
+	}
+	rev := <-payments
+	if rev == nil {
+		t.Fatal("reversal not created")
+	}
+	if rev.Account != "Bob" || rev.Direction != "outgoing" || rev.Reverses != want[1] {
+		t.Errorf("reversal: %s %s reverses %s, want Bob outgoing reverses %s", rev.Account, rev.Direction, rev.Reverses, want[1])
+	}
+
+	events, _ := c.Event.FromOffset(ctx, 0, wallet.OffsetOldest)
+	if e := <-events; e == nil || e.RequestID != requestID || e.Status != wallet.StatusAccepted {
+		t.Errorf("event: %+v, want accepted", e)
+	}
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatalf("process() error: %v", err)
+	}
+}
+
+// failingPayer can't create payments, e.g., the broker is unavailable.
+type failingPayer struct{}
+
+func (failingPayer) CreateTransferPayments(context.Context, *wallet.Transfer, ...*wallet.Payment) error {
+	return errors.New("broker unavailable")
+}
+
+func TestDaemon_process_fail(t *testing.T) {
+	c := memlog.NewClient(memlog.WithPartitions(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tr := wallet.Transfer{ID: requestID, From: "Alice", To: "Bob", Amount: *apd.New(5, -1), Currency: "USD"}
+	if err := c.Transfer.CreateTransfer(ctx, &tr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		// policy is what the error handler returns, nil skips the transfer.
+		policy error
+	}{
+		"skip": {nil},
+		"stop": {errors.New("stopped")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			failed := make(chan *kafka.MessageError, 1)
+			d := newDaemon(c)
+			d.payer = failingPayer{}
+			d.fail = func(_ context.Context, topic string, partition int32, offset int64, err error) error {
+				failed <- &kafka.MessageError{Topic: topic, Partition: partition, Offset: offset, Err: err}
+				return tc.policy
+			}
+			errc := make(chan error, 1)
+			go func() { errc <- d.process(ctx, 0) }()
+
+			merr := <-failed
+			if merr.Topic != kafka.DefaultTransferTopic || merr.Partition != tr.Partition || merr.Offset != tr.SequenceID {
+				t.Errorf("failed message: %s/%d@%d, want %s/%d@%d", merr.Topic, merr.Partition, merr.Offset, kafka.DefaultTransferTopic, tr.Partition, tr.SequenceID)
+			}
+
+			if tc.policy == nil {
+				cancel()
+			}
+			if err := <-errc; err != tc.policy {
+				t.Fatalf("process() error: %v, want %v", err, tc.policy)
+			}
+		})
+	}
+}
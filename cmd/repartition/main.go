@@ -0,0 +1,135 @@
+// Command repartition reports which accounts would move to another wallet.payment partition
+// if the partition count or the partitioner changed. An account's balance and payment IDs are kept
+// by the accountantd instance of the account's partition, so the moved accounts' state has to be migrated.
+// Accounts are read from stdin one per line.
+//
+//	$ printf "Alice\nBob\n" | repartition -partitions=2 -new-partitions=3
+//	Alice 1 -> 2
+//	1 of 2 accounts move
+//
+// With -migrate flag the accounts are taken from accountantd databases instead, and their balances and
+// payment IDs are moved to the databases of the new partitions. Stop accountantd and let it apply
+// all payments before partitions are added, then migrate the databases and start accountantd again.
+// Use -dry-run flag to see what would be moved.
+//
+//	$ repartition -migrate -partitions=2 -new-partitions=3 -new-partitioner=jump -dry-run
+//	Bob 0 -> 2: 1 balances, 3 payment IDs
+//	1 accounts move: 1 balances, 3 payment IDs (dry run)
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/facebookgo/flagenv"
+	"github.com/pkg/errors"
+
+	"github.com/marselester/distributed-payment/kafka"
+	"github.com/marselester/distributed-payment/rocks"
+)
+
+func main() {
+	partitioner := flag.String("partitioner", "hash", "Current partitioner of wallet.payment topic: hash, murmur2 or jump.")
+	partitions := flag.Int("partitions", 2, "Current number of partitions of wallet.payment topic.")
+	newPartitioner := flag.String("new-partitioner", "", "New partitioner, defaults to -partitioner.")
+	newPartitions := flag.Int("new-partitions", 0, "New number of partitions, defaults to -partitions.")
+	migrate := flag.Bool("migrate", false, "Move balances and payment IDs between accountantd databases according to the new partitions.")
+	dbname := flag.String("db", "dedup%d.db", "Name pattern of accountantd databases, %d is replaced with a partition number.")
+	dryRun := flag.Bool("dry-run", false, "Report what would be moved by -migrate without moving it.")
+	// Parse env values.
+	flagenv.Parse()
+	// Override env values with command line flag values.
+	flag.Parse()
+
+	if *newPartitioner == "" {
+		*newPartitioner = *partitioner
+	}
+	if *newPartitions == 0 {
+		*newPartitions = *partitions
+	}
+	if *partitions <= 0 || *newPartitions <= 0 {
+		log.Fatal("repartition: number of partitions must be positive")
+	}
+	from, err := kafka.PartitionerByName(*partitioner)
+	if err != nil {
+		log.Fatalf("repartition: %v", err)
+	}
+	to, err := kafka.PartitionerByName(*newPartitioner)
+	if err != nil {
+		log.Fatalf("repartition: %v", err)
+	}
+
+	if *migrate {
+		if err = migrateStores(*dbname, *partitions, *newPartitions, to, *dryRun); err != nil {
+			log.Fatalf("repartition: %v", err)
+		}
+		return
+	}
+
+	var total, moved int
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		account := strings.TrimSpace(s.Text())
+		if account == "" {
+			continue
+		}
+		total++
+
+		oldPartition := from([]byte(account), int32(*partitions))
+		newPartition := to([]byte(account), int32(*newPartitions))
+		if oldPartition != newPartition {
+			moved++
+			fmt.Printf("%s %d -> %d\n", account, oldPartition, newPartition)
+		}
+	}
+	if err = s.Err(); err != nil {
+		log.Fatalf("repartition: failed to read accounts: %v", err)
+	}
+	fmt.Printf("%d of %d accounts move\n", moved, total)
+}
+
+// migrateStores moves balances and payment IDs from the databases of the current partitions
+// to the databases of the partitions assigned by the new partitioner.
+// An account is looked up in the database it's actually kept in, so the current partitioner doesn't matter.
+func migrateStores(dbname string, partitions, newPartitions int, to kafka.PartitionFunc, dryRun bool) error {
+	n := partitions
+	if newPartitions > n {
+		n = newPartitions
+	}
+	stores := make([]*rocks.Client, n)
+	for i := range stores {
+		stores[i] = rocks.NewClient(rocks.WithDB(fmt.Sprintf(dbname, i)))
+		if err := stores[i].Open(); err != nil {
+			return errors.Wrapf(err, "failed to open RocksDB of partition %d", i)
+		}
+		defer stores[i].Close()
+	}
+
+	var accounts, balances, ids int
+	for from := 0; from < partitions; from++ {
+		moves, err := stores[from].MoveAccounts(func(account string) *rocks.Client {
+			return stores[to([]byte(account), int32(newPartitions))]
+		}, dryRun)
+		if err != nil {
+			return errors.Wrapf(err, "failed to move accounts of partition %d", from)
+		}
+
+		for _, m := range moves {
+			fmt.Printf("%s %d -> %d: %d balances, %d payment IDs\n", m.Account, from, to([]byte(m.Account), int32(newPartitions)), m.Balances, m.PaymentIDs)
+			accounts++
+			balances += m.Balances
+			ids += m.PaymentIDs
+		}
+	}
+
+	summary := fmt.Sprintf("%d accounts move: %d balances, %d payment IDs", accounts, balances, ids)
+	if dryRun {
+		summary += " (dry run)"
+	}
+	fmt.Println(summary)
+	return nil
+}
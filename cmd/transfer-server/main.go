@@ -1,11 +1,15 @@
 // transfer-server is an HTTP server API for clients to create money transfers.
 // It exposes REST-style API with basic validation of transfer requests.
+// Transfer statuses are kept in RocksDB based on events from wallet.transfer_event topic,
+// so clients can learn whether the money actually moved.
+// Messages are kept in Kafka or, with -backend=file, in a file log shared with paymentd and accountantd on the same host.
 package main
 
 import (
 	"context"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,15 +17,26 @@ import (
 
 	"github.com/facebookgo/flagenv"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/filelog"
 	"github.com/marselester/distributed-payment/kafka"
 	"github.com/marselester/distributed-payment/rest"
+	"github.com/marselester/distributed-payment/rocks"
 )
 
 func main() {
 	apiAddr := flag.String("http", "127.0.0.1:8000", "HTTP API address.")
-	broker := flag.String("broker", "127.0.0.1:9092", "Broker address to connect to.")
+	backend := flag.String("backend", "kafka", "Where messages are kept: kafka or file.")
+	// Kafka connection flags, e.g., -broker, -tls, -acks.
+	connOptions := kafka.ConnFlags(flag.CommandLine)
+	// File log flags, e.g., -log-dir, -log-sync.
+	logOptions := filelog.Flags(flag.CommandLine)
+	dbname := flag.String("db", "transfer.db", "RocksDB database where transfer statuses are kept.")
+	codecName := flag.String("codec", "json", "How transfer requests are encoded in Kafka: json, protobuf or avro.")
+	metricsAddr := flag.String("metrics", "", "Address of HTTP listener which serves Prometheus metrics at /metrics, e.g., 127.0.0.1:9100.")
 	debug := flag.Bool("debug", false, "Enable debug mode.")
 	// Parse env values.
 	flagenv.Parse()
@@ -36,17 +51,90 @@ func main() {
 		logger = &wallet.NoopLogger{}
 	}
 
-	c := kafka.NewClient(
-		kafka.WithBrokers(*broker),
-		kafka.WithLogger(logger),
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr); err != nil {
+			log.Fatalf("tranfser-server: %v", err)
+		}
+	}
+
+	store := rocks.NewClient(
+		rocks.WithDB(*dbname),
+		rocks.WithLogger(logger),
 	)
-	if err := c.Open(); err != nil {
-		log.Fatalf("tranfser-server: failed to connect to Kafka: %v", err)
+	if err := store.Open(); err != nil {
+		log.Fatalf("tranfser-server: failed to open RocksDB: %v", err)
+	}
+	defer store.Close()
+
+	var (
+		transfers  wallet.TransferService
+		events     wallet.TransferEventService
+		partitions []int32
+		// processed reports an applied transfer event to the consumer metrics, see kafka.Client.Processed.
+		processed func(topic string, partition int32, offset int64)
+	)
+	switch *backend {
+	case "kafka":
+		codec, err := kafka.CodecByName(*codecName)
+		if err != nil {
+			log.Fatalf("tranfser-server: %v", err)
+		}
+
+		opts, err := connOptions()
+		if err != nil {
+			log.Fatalf("tranfser-server: %v", err)
+		}
+		if *metricsAddr != "" {
+			// Consumer lag and throughput of the partitions are reported on the metrics listener.
+			opts = append(opts, kafka.WithMetrics(kafka.NewPrometheusMetrics()))
+		}
+		c := kafka.NewClient(append(opts,
+			kafka.WithCodec(codec),
+			kafka.WithLogger(logger),
+			// Retried requests are detected by request ID, so they don't end up in Kafka twice.
+			kafka.WithTransferStatusService(store.TransferStatus),
+		)...)
+		if err := c.Open(); err != nil {
+			log.Fatalf("tranfser-server: failed to connect to Kafka: %v", err)
+		}
+		defer c.Close()
+
+		if partitions, err = c.Partitions(kafka.DefaultTransferEventTopic); err != nil {
+			log.Fatalf("tranfser-server: failed to get partitions of %s: %v", kafka.DefaultTransferEventTopic, err)
+		}
+		transfers, events = c.Transfer, c.Event
+		processed = c.Processed
+	case "file":
+		opts, err := logOptions()
+		if err != nil {
+			log.Fatalf("tranfser-server: %v", err)
+		}
+		c := filelog.NewClient(append(opts,
+			filelog.WithLogger(logger),
+			filelog.WithTransferStatusService(store.TransferStatus),
+		)...)
+		if err := c.Open(); err != nil {
+			log.Fatalf("tranfser-server: failed to open file log: %v", err)
+		}
+		defer c.Close()
+
+		partitions = c.Partitions()
+		transfers, events = c.Transfer, c.Event
+		// Consumer metrics are reported only by Kafka backend.
+		processed = func(topic string, partition int32, offset int64) {}
+	default:
+		log.Fatalf("tranfser-server: unknown backend %q", *backend)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, p := range partitions {
+		go watchEvents(ctx, events, store.TransferStatus, processed, p)
 	}
-	defer c.Close()
 
 	api := rest.NewServer(
-		rest.WithTransferService(c.Transfer),
+		rest.WithTransferService(transfers),
+		rest.WithTransferStatusService(store.TransferStatus),
 		rest.WithLogger(logger),
 	)
 
@@ -80,3 +168,31 @@ func main() {
 	<-idleConnsClosed
 	log.Print("tranfser-server: api stopped")
 }
+
+// watchEvents applies transfer events from the partition to the transfer status service until ctx is cancelled.
+// Events are read from the oldest offset, since applying an event twice doesn't change a transfer status.
+// Their offsets aren't committed, so processed is called once an event is applied.
+func watchEvents(ctx context.Context, es wallet.TransferEventService, ss wallet.TransferStatusService, processed func(topic string, partition int32, offset int64), partition int32) {
+	events, errc := es.FromOffset(ctx, partition, wallet.OffsetOldest)
+	for e := range events {
+		if err := ss.ApplyEvent(e); err != nil {
+			log.Printf("tranfser-server: failed to apply transfer event %d:%d: %v", e.Partition, e.SequenceID, err)
+		}
+		processed(kafka.DefaultTransferEventTopic, e.Partition, e.SequenceID)
+	}
+	if err := <-errc; err != nil && err != context.Canceled {
+		log.Printf("tranfser-server: transfer events fetch failed: %v", err)
+	}
+}
+
+// serveMetrics serves Prometheus metrics at /metrics in background, e.g., consumer lag of the partitions.
+func serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for metrics")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.Serve(ln, mux)
+	return nil
+}
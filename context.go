@@ -0,0 +1,18 @@
+package wallet
+
+import "context"
+
+// traceKey is a context key of the trace ID.
+type traceKey struct{}
+
+// WithTraceID returns a copy of ctx which carries the trace ID, e.g., an ID of the HTTP request
+// that caused a transfer. Storages put the ID into messages, so the payments can be traced back to the request.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceID)
+}
+
+// TraceID returns the trace ID stored in ctx or an empty string.
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceKey{}).(string)
+	return traceID
+}
@@ -4,6 +4,17 @@ package wallet
 const (
 	ErrTransferNotFound = Error("transfer not found")
 	ErrTransferExists   = Error("transfer already exists")
+	ErrTransferConflict = Error("transfer request ID is already used by another transfer")
+)
+
+// Payment errors.
+const (
+	ErrInsufficientFunds = Error("insufficient funds")
+)
+
+// Ledger service errors.
+const (
+	ErrOffsetNotFound = Error("offset not found")
 )
 
 // Error defines errors which are relevant to all wallet services.
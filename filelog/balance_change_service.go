@@ -0,0 +1,104 @@
+package filelog
+
+import (
+	"context"
+	"encoding/json"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// BalanceChangeService represents a file log service to store balance changes.
+// Unlike Kafka the log is never compacted, so Latest reads all the changes.
+type BalanceChangeService struct {
+	client *Client
+}
+
+// CreateBalanceChange appends the balance change to the partition of the account,
+// so it lands in the partition with the same number as the account's payments.
+func (s *BalanceChangeService) CreateBalanceChange(ctx context.Context, b *wallet.BalanceChange) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	r := record{Value: v}
+	s.client.setMetadata(ctx, &r)
+	if b.Partition, b.SequenceID, err = s.client.balances.append(b.Account, &r); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "balance change not created", "body", v, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "balance change created", "partition", b.Partition, "offset", b.SequenceID, "body", v)
+	return nil
+}
+
+// FromOffset returns a channel of balance changes from the given partition starting at offset.
+func (s *BalanceChangeService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.BalanceChange, <-chan error) {
+	changes := make(chan *wallet.BalanceChange)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the changes channel after read returns.
+		defer close(changes)
+
+		err := s.client.read(ctx, s.client.balances, partition, offset, func(offset int64, r *record) error {
+			b := wallet.BalanceChange{}
+			if err := json.Unmarshal(r.Value, &b); err != nil {
+				return err
+			}
+			b.Partition = partition
+			b.SequenceID = offset
+
+			select {
+			case changes <- &b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return changes, errc
+}
+
+// Latest returns the latest balance change of each account appended to the partition by the time of the call.
+func (s *BalanceChangeService) Latest(ctx context.Context, partition int32) (map[string]*wallet.BalanceChange, error) {
+	t := s.client.balances
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return nil, ErrPartitionNotFound
+	}
+	next, err := t.partitions[partition].nextOffset()
+	if err != nil {
+		return nil, err
+	}
+	oldest, err := t.partitions[partition].oldestOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*wallet.BalanceChange)
+	if oldest == next {
+		return latest, nil
+	}
+	err = s.client.read(ctx, t, partition, oldest, func(offset int64, r *record) error {
+		b := wallet.BalanceChange{}
+		if err := json.Unmarshal(r.Value, &b); err != nil {
+			return err
+		}
+		b.Partition = partition
+		b.SequenceID = offset
+		latest[b.Account] = &b
+
+		// Records appended after the call aren't waited for.
+		if offset >= next-1 {
+			return errEndOfPartition
+		}
+		return nil
+	})
+	if err != errEndOfPartition {
+		return nil, err
+	}
+	return latest, nil
+}
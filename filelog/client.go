@@ -0,0 +1,536 @@
+// Package filelog implements wallet services on top of a partitioned log kept in local files
+// and provides the Client to access them. It lets the wallet run on a single host without a broker.
+//
+// Each topic is a directory with a subdirectory per partition, e.g., wallet.payment/0.
+// A partition consists of segments: a log file with CRC-protected records and an index file
+// with positions of the records, so a record is found by its offset without scanning the log.
+// Messages are assigned to partitions by hashing their keys the same way sarama does.
+// Appends are serialized with a file lock, so transfer-server, paymentd and accountantd
+// can share the log directory. FromOffset blocks waiting for new records until its context is cancelled.
+package filelog
+
+import (
+	"context"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+const (
+	// DefaultDir is a default directory where topics are kept.
+	DefaultDir = "wallet.log"
+	// DefaultPartitions is a default number of partitions of each topic.
+	DefaultPartitions = 2
+	// DefaultSegmentBytes is a default size of a segment's log file after which a new segment is started.
+	DefaultSegmentBytes = 64 << 20
+)
+
+// SyncPolicy defines when appended records are flushed to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes every record before it's acknowledged, so no acknowledged record is lost on power failure.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes records periodically, records appended since the last flush could be lost.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// Errors returned when a partition is read or an offset is committed.
+var (
+	ErrTopicNotFound     = errors.New("filelog: topic not found")
+	ErrPartitionNotFound = errors.New("filelog: partition not found")
+	ErrOffsetOutOfRange  = errors.New("filelog: offset out of range")
+	ErrNoGroup           = errors.New("filelog: consumer group is not set")
+)
+
+// Client represents a client to the file log.
+type Client struct {
+	Transfer  wallet.TransferService
+	Payment   wallet.PaymentService
+	Rejection wallet.RejectionService
+	Event     wallet.TransferEventService
+	// BalanceChange is used by accountantd to publish balances and restore them.
+	BalanceChange wallet.BalanceChangeService
+
+	logger wallet.Logger
+	// status is used to detect retried transfer requests, see WithTransferStatusService.
+	status     wallet.TransferStatusService
+	transfers  *topic
+	payments   *topic
+	rejections *topic
+	events     *topic
+	balances   *topic
+	// done stops the periodic sync when the Client is closed.
+	done   chan struct{}
+	synced chan struct{}
+
+	copts connOption
+}
+
+// connOption holds the log settings.
+type connOption struct {
+	dir          string
+	partitions   int
+	segmentBytes int64
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	// group is a name of the consumer whose offsets are committed.
+	group string
+	// serviceName is kept in records to tell which service created them.
+	serviceName string
+}
+
+// NewClient returns a new Client which provides you with
+// transfer, payment, rejection, transfer event and balance change services based on the file log.
+// By default each topic has two partitions, records are flushed on every append, and logs are discarded.
+func NewClient(options ...ConfigOption) *Client {
+	c := Client{
+		logger: &wallet.NoopLogger{},
+		copts: connOption{
+			dir:          DefaultDir,
+			partitions:   DefaultPartitions,
+			segmentBytes: DefaultSegmentBytes,
+			syncPolicy:   SyncAlways,
+			serviceName:  filepath.Base(os.Args[0]),
+		},
+	}
+	for _, opt := range options {
+		opt(&c)
+	}
+
+	c.Transfer = &TransferService{client: &c}
+	c.Payment = &PaymentService{client: &c}
+	c.Rejection = &RejectionService{client: &c}
+	c.Event = &TransferEventService{client: &c}
+	c.BalanceChange = &BalanceChangeService{client: &c}
+	return &c
+}
+
+// ConfigOption configures the Client.
+type ConfigOption func(*Client)
+
+// WithLogger configures a logger to debug interactions with the log.
+func WithLogger(l wallet.Logger) ConfigOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithDir sets a directory where topics are kept.
+func WithDir(dir string) ConfigOption {
+	return func(c *Client) {
+		c.copts.dir = dir
+	}
+}
+
+// WithPartitions sets a number of partitions of each topic.
+// All processes sharing the directory must use the same number, otherwise keys land in different partitions.
+func WithPartitions(n int) ConfigOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.copts.partitions = n
+		}
+	}
+}
+
+// WithSegmentBytes sets a size of a segment's log file after which a new segment is started.
+func WithSegmentBytes(n int64) ConfigOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.copts.segmentBytes = n
+		}
+	}
+}
+
+// WithSync sets when records are flushed to disk. The interval is used only by SyncInterval policy.
+func WithSync(policy SyncPolicy, interval time.Duration) ConfigOption {
+	return func(c *Client) {
+		c.copts.syncPolicy = policy
+		c.copts.syncInterval = interval
+	}
+}
+
+// WithGroup sets a name of the consumer whose offsets are committed, e.g., paymentd.
+func WithGroup(group string) ConfigOption {
+	return func(c *Client) {
+		c.copts.group = group
+	}
+}
+
+// WithServiceName sets a name of the service which produces records, e.g., paymentd.
+// It's kept in the records' metadata.
+func WithServiceName(name string) ConfigOption {
+	return func(c *Client) {
+		c.copts.serviceName = name
+	}
+}
+
+// WithTransferStatusService makes TransferService detect duplicate request IDs.
+func WithTransferStatusService(s wallet.TransferStatusService) ConfigOption {
+	return func(c *Client) {
+		c.status = s
+	}
+}
+
+// Open creates the topics' directories unless they exist.
+// With SyncInterval policy it starts flushing the records in background.
+func (c *Client) Open() error {
+	if c.copts.syncPolicy == SyncInterval && c.copts.syncInterval <= 0 {
+		return errors.New("filelog: sync interval must be positive")
+	}
+
+	var err error
+	if c.transfers, err = c.openTopic("wallet.transfer_request"); err != nil {
+		return err
+	}
+	if c.payments, err = c.openTopic("wallet.payment"); err != nil {
+		return err
+	}
+	if c.rejections, err = c.openTopic("wallet.payment_rejection"); err != nil {
+		return err
+	}
+	if c.events, err = c.openTopic("wallet.transfer_event"); err != nil {
+		return err
+	}
+	if c.balances, err = c.openTopic("wallet.balance"); err != nil {
+		return err
+	}
+
+	if c.copts.syncPolicy == SyncInterval {
+		c.done = make(chan struct{})
+		c.synced = make(chan struct{})
+		go c.syncPeriodically()
+	}
+	return nil
+}
+
+// Close flushes the records and closes the files.
+func (c *Client) Close() error {
+	if c.done != nil {
+		close(c.done)
+		<-c.synced
+	}
+
+	var err error
+	for _, t := range []*topic{c.transfers, c.payments, c.rejections, c.events, c.balances} {
+		if t == nil {
+			continue
+		}
+		for _, p := range t.partitions {
+			if cerr := p.close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// syncPeriodically flushes the partitions until the Client is closed.
+func (c *Client) syncPeriodically() {
+	defer close(c.synced)
+
+	ticker := time.NewTicker(c.copts.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		for _, t := range []*topic{c.transfers, c.payments, c.rejections, c.events, c.balances} {
+			for _, p := range t.partitions {
+				if err := p.sync(); err != nil {
+					c.logger.Log("level", "debug", "msg", "partition not synced", "dir", p.dir, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// openTopic opens the topic's partitions.
+func (c *Client) openTopic(name string) (*topic, error) {
+	t := topic{
+		name:       name,
+		partitions: make([]*partition, c.copts.partitions),
+	}
+	for i := range t.partitions {
+		dir := filepath.Join(c.copts.dir, name, strconv.Itoa(i))
+		p, err := openPartition(dir, c.copts.segmentBytes, c.copts.syncPolicy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "filelog: open partition %s", dir)
+		}
+		t.partitions[i] = p
+	}
+	return &t, nil
+}
+
+// Partitions returns partition numbers of a topic. All topics have the same number of partitions.
+func (c *Client) Partitions() []int32 {
+	ps := make([]int32, c.copts.partitions)
+	for i := range ps {
+		ps[i] = int32(i)
+	}
+	return ps
+}
+
+// OffsetRange returns the oldest available offset of the topic's partition and
+// the offset of the record that will be appended next, e.g., wallet.payment.
+func (c *Client) OffsetRange(name string, partition int32) (oldest, newest int64, err error) {
+	t, err := c.topicPartition(name, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	if oldest, err = t.partitions[partition].oldestOffset(); err != nil {
+		return 0, 0, err
+	}
+	if newest, err = t.partitions[partition].nextOffset(); err != nil {
+		return 0, 0, err
+	}
+	return oldest, newest, nil
+}
+
+// CommittedOffset returns the group's offset following the last committed record of the topic's partition,
+// or wallet.OffsetOldest when nothing was committed yet.
+func (c *Client) CommittedOffset(name string, partition int32) (int64, error) {
+	t, err := c.topicPartition(name, partition)
+	if err != nil {
+		return 0, err
+	}
+	if c.copts.group == "" {
+		return 0, ErrNoGroup
+	}
+	b, err := ioutil.ReadFile(c.offsetPath(t, partition))
+	if os.IsNotExist(err) {
+		return wallet.OffsetOldest, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// topicPartition returns the topic by its name, e.g., wallet.payment, if it has the partition.
+func (c *Client) topicPartition(name string, partition int32) (*topic, error) {
+	var t *topic
+	for _, tt := range []*topic{c.transfers, c.payments, c.rejections, c.events, c.balances} {
+		if tt.name == name {
+			t = tt
+		}
+	}
+	if t == nil {
+		return nil, ErrTopicNotFound
+	}
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return nil, ErrPartitionNotFound
+	}
+	return t, nil
+}
+
+// PartitionHandler processes a partition of a topic, see Consume.
+type PartitionHandler func(ctx context.Context, partition int32) error
+
+// Consume calls h in a separate goroutine for each partition, since there is no one to share them with.
+// It blocks until ctx is cancelled or h returns an error, then the rest of the handlers are stopped.
+func (c *Client) Consume(ctx context.Context, h PartitionHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partitions := c.Partitions()
+	errc := make(chan error, len(partitions))
+	for _, p := range partitions {
+		go func(p int32) {
+			errc <- h(ctx, p)
+		}(p)
+	}
+
+	var err error
+	for range partitions {
+		if herr := <-errc; herr != nil && err == nil {
+			err = herr
+			cancel()
+		}
+	}
+	return err
+}
+
+// setMetadata sets metadata of a record being appended.
+func (c *Client) setMetadata(ctx context.Context, r *record) {
+	r.TraceID = wallet.TraceID(ctx)
+	r.Producer = c.copts.serviceName
+	r.CreatedAt = time.Now().UTC()
+}
+
+// offsetPath returns a path of a file where the group's committed offset of the partition is kept.
+func (c *Client) offsetPath(t *topic, partition int32) string {
+	return filepath.Join(c.copts.dir, "offsets", c.copts.group, t.name, strconv.Itoa(int(partition)))
+}
+
+// commit stores the offset following the processed one. The file is replaced atomically by renaming,
+// so a crash leaves either the previous or the new offset.
+func (c *Client) commit(t *topic, partition int32, offset int64) error {
+	if c.copts.group == "" {
+		return ErrNoGroup
+	}
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return ErrPartitionNotFound
+	}
+
+	path := c.offsetPath(t, partition)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".offset")
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(strconv.FormatInt(offset+1, 10)); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "filelog: commit offset")
+	}
+
+	c.logger.Log("level", "debug", "msg", "offset committed", "topic", t.name, "partition", partition, "offset", offset)
+	return nil
+}
+
+// committedOffset returns the offset following the last committed one or the oldest offset if none was committed.
+func (c *Client) committedOffset(t *topic, partition int32) (int64, error) {
+	offset, err := c.CommittedOffset(t.name, partition)
+	if err == nil && offset == wallet.OffsetOldest {
+		return t.partitions[partition].oldestOffset()
+	}
+	return offset, err
+}
+
+// topic is a partitioned log.
+type topic struct {
+	name       string
+	partitions []*partition
+}
+
+// partitionFor returns a partition of the key. It is sarama's hash partitioner,
+// so a key lands in the partition with the same number as in Kafka.
+func (t *topic) partitionFor(key string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p := int32(h.Sum32()) % int32(len(t.partitions))
+	if p < 0 {
+		p = -p
+	}
+	return p
+}
+
+// append appends the record to the partition of the key and returns the partition number and the offset.
+func (t *topic) append(key string, r *record) (int32, int64, error) {
+	n := t.partitionFor(key)
+	offset, err := t.partitions[n].append(r)
+	return n, offset, err
+}
+
+// offsetForTime returns the offset of the first record of the partition created at or after since.
+func (t *topic) offsetForTime(partition int32, since time.Time) (int64, error) {
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return 0, ErrPartitionNotFound
+	}
+	return t.partitions[partition].offsetForTime(since)
+}
+
+// read calls f for each record of the partition starting at offset.
+// It waits for new records until ctx is cancelled, then the ctx error is returned.
+// Reading stops when f returns an error.
+func (c *Client) read(ctx context.Context, t *topic, partition int32, offset int64, f func(offset int64, r *record) error) error {
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return ErrPartitionNotFound
+	}
+	p := t.partitions[partition]
+
+	oldest, err := p.oldestOffset()
+	if err != nil {
+		return err
+	}
+	next, err := p.nextOffset()
+	if err != nil {
+		return err
+	}
+	switch offset {
+	case wallet.OffsetOldest:
+		offset = oldest
+	case wallet.OffsetNewest:
+		offset = next
+	case wallet.OffsetCommitted:
+		if offset, err = c.committedOffset(t, partition); err != nil {
+			return err
+		}
+	}
+	if offset < oldest || offset > next {
+		return ErrOffsetOutOfRange
+	}
+
+	var seg *segmentReader
+	defer func() { seg.close() }()
+	for {
+		// The appended channel is taken before reading, so an append right after the read isn't missed.
+		p.mu.Lock()
+		appended := p.appended
+		p.mu.Unlock()
+
+		var r *record
+		if seg != nil {
+			r, err = seg.readAt(offset)
+		}
+		// The offset could be in the next segment or in a segment created by another process.
+		if seg == nil || err == errEndOfPartition {
+			var s *segmentReader
+			switch s, err = p.openSegmentReader(offset); err {
+			case nil:
+				seg.close()
+				seg = s
+				r, err = seg.readAt(offset)
+			case errEndOfPartition:
+			default:
+				return err
+			}
+		}
+
+		switch err {
+		case nil:
+			if err = f(offset, r); err != nil {
+				return err
+			}
+			offset++
+			continue
+		case errEndOfPartition:
+		default:
+			return err
+		}
+
+		// Records appended by other processes are noticed on the next poll.
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-appended:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		timer.Stop()
+	}
+}
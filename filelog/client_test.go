@@ -0,0 +1,270 @@
+package filelog_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/filelog"
+)
+
+// Ensure filelog services implement wallet interfaces.
+var (
+	_ wallet.TransferService      = &filelog.TransferService{}
+	_ wallet.PaymentService       = &filelog.PaymentService{}
+	_ wallet.RejectionService     = &filelog.RejectionService{}
+	_ wallet.TransferEventService = &filelog.TransferEventService{}
+	_ wallet.BalanceChangeService = &filelog.BalanceChangeService{}
+)
+
+// openClient opens a Client in a temporary directory. The returned func closes the Client and removes the directory.
+func openClient(t *testing.T, options ...filelog.ConfigOption) (*filelog.Client, string, func()) {
+	dir, err := ioutil.TempDir("", "filelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := filelog.NewClient(append([]filelog.ConfigOption{filelog.WithDir(dir)}, options...)...)
+	if err = c.Open(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return c, dir, func() {
+		c.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPaymentService_FromOffset(t *testing.T) {
+	c, _, teardown := openClient(t)
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	alice := wallet.Payment{ID: "1:outgoing:Alice", Account: "Alice", Amount: *apd.New(5, -1), Currency: "USD"}
+	if err := c.Payment.CreatePayment(wallet.WithTraceID(ctx, "abc"), &alice); err != nil {
+		t.Fatal(err)
+	}
+	payments, errc := c.Payment.FromOffset(ctx, alice.Partition, wallet.OffsetOldest)
+	p := <-payments
+	if p.ID != alice.ID || p.SequenceID != 0 || p.Meta.TraceID != "abc" {
+		t.Fatalf("payment: %s@%d trace %q, want %s@0 trace abc", p.ID, p.SequenceID, p.Meta.TraceID, alice.ID)
+	}
+
+	// FromOffset blocks until the next payment of the account is appended to the same partition.
+	again := wallet.Payment{ID: "2:outgoing:Alice", Account: "Alice", Amount: *apd.New(1, 0), Currency: "USD"}
+	go c.Payment.CreatePayment(ctx, &again)
+	p = <-payments
+	if p.ID != again.ID || p.Partition != alice.Partition || p.SequenceID != 1 {
+		t.Fatalf("payment: %s %d:%d, want %s %d:1", p.ID, p.Partition, p.SequenceID, again.ID, alice.Partition)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("error: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPaymentService_segments(t *testing.T) {
+	c, dir, teardown := openClient(t, filelog.WithPartitions(1), filelog.WithSegmentBytes(1))
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ids := []string{"1:incoming:Bob", "2:incoming:Bob", "3:incoming:Bob"}
+	for _, id := range ids {
+		p := wallet.Payment{ID: id, Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := c.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each segment holds one record, since it's full after the first one.
+	segments, err := filepath.Glob(filepath.Join(dir, "wallet.payment", "0", "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != len(ids) {
+		t.Fatalf("segments: %d, want %d", len(segments), len(ids))
+	}
+
+	payments, _ := c.Payment.FromOffset(ctx, 0, 1)
+	for _, id := range ids[1:] {
+		if p := <-payments; p.ID != id {
+			t.Fatalf("payment: %s, want %s", p.ID, id)
+		}
+	}
+}
+
+func TestPaymentService_CommitOffset(t *testing.T) {
+	c, dir, teardown := openClient(t, filelog.WithPartitions(1), filelog.WithGroup("accountantd"))
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, id := range []string{"1:incoming:Bob", "2:incoming:Bob"} {
+		p := wallet.Payment{ID: id, Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := c.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Payment.CommitOffset(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log and the committed offset survive a restart.
+	reopened := filelog.NewClient(filelog.WithDir(dir), filelog.WithPartitions(1), filelog.WithGroup("accountantd"))
+	if err := reopened.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	payments, _ := reopened.Payment.FromOffset(ctx, 0, wallet.OffsetCommitted)
+	if p := <-payments; p.ID != "2:incoming:Bob" {
+		t.Fatalf("payment: %s, want 2:incoming:Bob", p.ID)
+	}
+}
+
+func TestPaymentService_FromTime(t *testing.T) {
+	c, _, teardown := openClient(t, filelog.WithPartitions(1), filelog.WithSegmentBytes(1))
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var since time.Time
+	for i, id := range []string{"1:incoming:Bob", "2:incoming:Bob", "3:incoming:Bob"} {
+		if i == 2 {
+			time.Sleep(time.Millisecond)
+			since = time.Now()
+			time.Sleep(time.Millisecond)
+		}
+		p := wallet.Payment{ID: id, Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := c.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payments, _ := c.Payment.FromTime(ctx, 0, since)
+	if p := <-payments; p.ID != "3:incoming:Bob" || p.SequenceID != 2 {
+		t.Fatalf("payment: %s@%d, want 3:incoming:Bob@2", p.ID, p.SequenceID)
+	}
+
+	// Only new payments are read when all of them were created before since.
+	payments, errc := c.Payment.FromTime(ctx, 0, time.Now())
+	cancel()
+	if _, ok := <-payments; ok {
+		t.Fatal("expected no payments")
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("error: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestTransferService_FromOffset_outOfRange(t *testing.T) {
+	c, _, teardown := openClient(t)
+	defer teardown()
+	transfers, errc := c.Transfer.FromOffset(context.Background(), 0, 1)
+	if _, ok := <-transfers; ok {
+		t.Fatal("expected transfers channel to be closed")
+	}
+	if err := <-errc; err != filelog.ErrOffsetOutOfRange {
+		t.Fatalf("error: %v, want %v", err, filelog.ErrOffsetOutOfRange)
+	}
+}
+
+func TestBalanceChangeService_Latest(t *testing.T) {
+	c, _, teardown := openClient(t, filelog.WithPartitions(1))
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	latest, err := c.BalanceChange.Latest(ctx, 0)
+	if err != nil || len(latest) != 0 {
+		t.Fatalf("empty partition: %v %v", latest, err)
+	}
+
+	changes := []wallet.BalanceChange{
+		{Account: "Alice", Balances: []wallet.Balance{{Currency: "USD", Amount: *apd.New(1, 0)}}, PaymentOffset: 0},
+		{Account: "Bob", Balances: []wallet.Balance{{Currency: "USD", Amount: *apd.New(2, 0)}}, PaymentOffset: 1},
+		{Account: "Alice", Balances: []wallet.Balance{{Currency: "USD", Amount: *apd.New(4, -1)}}, PaymentOffset: 2},
+	}
+	for i := range changes {
+		if err = c.BalanceChange.CreateBalanceChange(ctx, &changes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if latest, err = c.BalanceChange.Latest(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("accounts: %d, want 2", len(latest))
+	}
+	if b := latest["Alice"]; b.SequenceID != 2 || b.PaymentOffset != 2 || b.Balances[0].Amount.Text('f') != "0.4" {
+		t.Fatalf("Alice: %+v, want 0.4 USD at offset 2", b)
+	}
+}
+
+func TestPaymentService_CreatePayment_repair(t *testing.T) {
+	// The Client is reopened, so it's closed separately from the directory removal.
+	c, dir, _ := openClient(t, filelog.WithPartitions(1))
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	first := wallet.Payment{ID: "1:incoming:Bob", Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+	if err := c.Payment.CreatePayment(ctx, &first); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// A process crashed in the middle of an append: a record was partially written to the log,
+	// and a part of the next index entry was written as well.
+	segment := filepath.Join(dir, "wallet.payment", "0", "00000000000000000000")
+	for ext, tail := range map[string][]byte{".log": []byte("\x00\x00\x01"), ".index": []byte("\x00\x00\x00")} {
+		f, err := os.OpenFile(segment+ext, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write(tail); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	c = filelog.NewClient(filelog.WithDir(dir), filelog.WithPartitions(1))
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ids := []string{"2:incoming:Bob", "3:incoming:Bob"}
+	for _, id := range ids {
+		p := wallet.Payment{ID: id, Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := c.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payments, _ := c.Payment.FromOffset(ctx, 0, wallet.OffsetOldest)
+	for i, id := range append([]string{first.ID}, ids...) {
+		p := <-payments
+		if p == nil {
+			t.Fatalf("payment %d not found", i)
+		}
+		if p.ID != id || p.SequenceID != int64(i) {
+			t.Fatalf("payment: %s@%d, want %s@%d", p.ID, p.SequenceID, id, i)
+		}
+	}
+
+	info, err := os.Stat(segment + ".index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 3*8 {
+		t.Errorf("index size: %d, want %d", info.Size(), 3*8)
+	}
+}
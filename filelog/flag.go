@@ -0,0 +1,39 @@
+package filelog
+
+import (
+	"flag"
+
+	"github.com/pkg/errors"
+)
+
+// Flags registers command line flags of the file log in fs, e.g., -log-dir or -log-sync.
+// The returned function makes the Client's options once the flags are parsed.
+func Flags(fs *flag.FlagSet) func() ([]ConfigOption, error) {
+	dir := fs.String("log-dir", DefaultDir, "Directory of the file log shared by the commands.")
+	partitions := fs.Int("log-partitions", DefaultPartitions, "Number of partitions of each topic of the file log.")
+	segmentBytes := fs.Int64("log-segment-bytes", DefaultSegmentBytes, "Size of a segment file after which a new segment is started.")
+	sync := fs.String("log-sync", "always", "When records are flushed to disk: always, interval or never.")
+	syncInterval := fs.Duration("log-sync-interval", 0, "How often records are flushed to disk with -log-sync=interval.")
+
+	return func() ([]ConfigOption, error) {
+		var policy SyncPolicy
+		switch *sync {
+		case "always":
+			policy = SyncAlways
+		case "interval":
+			policy = SyncInterval
+		case "never":
+			policy = SyncNever
+		default:
+			return nil, errors.Errorf("unknown log sync policy %q", *sync)
+		}
+
+		opts := []ConfigOption{
+			WithDir(*dir),
+			WithPartitions(*partitions),
+			WithSegmentBytes(*segmentBytes),
+			WithSync(policy, *syncInterval),
+		}
+		return opts, nil
+	}
+}
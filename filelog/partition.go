@@ -0,0 +1,472 @@
+package filelog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+const (
+	// indexEntrySize is a size of an index entry which is a position of a record in the segment's log file.
+	indexEntrySize = 8
+	// recordHeaderSize is a size of a record's header: CRC-32 checksum and length of the payload.
+	recordHeaderSize = 8
+	// pollInterval is how often a reader checks for records appended by other processes.
+	pollInterval = 100 * time.Millisecond
+)
+
+// errEndOfPartition is returned when there is no record at the offset yet.
+var errEndOfPartition = errors.New("filelog: end of partition")
+
+// record is a payload of a log entry: a message's value and its metadata.
+type record struct {
+	Value     json.RawMessage `json:"value"`
+	TraceID   string          `json:"trace_id,omitempty"`
+	Producer  string          `json:"producer,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// meta returns the record's metadata.
+func (r *record) meta() wallet.Metadata {
+	return wallet.Metadata{
+		TraceID:   r.TraceID,
+		Producer:  r.Producer,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+// partition is a directory of segments, e.g., wallet.payment/0/00000000000000000000.log.
+// A segment consists of a log file with records and an index file with positions of the records,
+// a segment's name is the offset of its first record. Records are appended to the last segment
+// until it grows over the segment size. Several processes can append to the same partition,
+// the appends are serialized with a file lock.
+type partition struct {
+	dir          string
+	segmentBytes int64
+	syncPolicy   SyncPolicy
+
+	// mu guards the active segment's files and serializes appends within the process.
+	mu    sync.Mutex
+	base  int64
+	log   *os.File
+	index *os.File
+	// dirty tells whether there are appended records which weren't synced yet.
+	dirty bool
+	// appended is closed and replaced when a record is appended by this process to wake up the readers.
+	// Records appended by other processes are noticed by polling.
+	appended chan struct{}
+}
+
+// openPartition creates the partition's directory if needed.
+func openPartition(dir string, segmentBytes int64, policy SyncPolicy) (*partition, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := partition{
+		dir:          dir,
+		segmentBytes: segmentBytes,
+		syncPolicy:   policy,
+		appended:     make(chan struct{}),
+	}
+	return &p, nil
+}
+
+// segmentPath returns a path of the segment's log or index file.
+func (p *partition) segmentPath(base int64, ext string) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+// segments returns base offsets of the partition's segments in ascending order.
+func (p *partition) segments() ([]int64, error) {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []int64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(name, ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+// append writes the record to the active segment and returns its offset.
+// The log is written before the index, so the index never points to a missing record.
+// A partially written record or index entry is truncated before the next append, see repair.
+func (p *partition) append(r *record) (int64, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	entry := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(entry[0:4], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(entry[4:8], uint32(len(payload)))
+	copy(entry[recordHeaderSize:], payload)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	unlock, err := p.lockFile()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = p.openActiveSegment(); err != nil {
+		return 0, err
+	}
+	indexInfo, err := p.index.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// Another process could have crashed in the middle of an index write since the segment was opened.
+	if indexInfo.Size()%indexEntrySize != 0 {
+		if err = p.repair(); err != nil {
+			return 0, err
+		}
+		if indexInfo, err = p.index.Stat(); err != nil {
+			return 0, err
+		}
+	}
+	logInfo, err := p.log.Stat()
+	if err != nil {
+		return 0, err
+	}
+	n := indexInfo.Size() / indexEntrySize
+	offset := p.base + n
+	pos := logInfo.Size()
+	// The active segment is rolled when it's full.
+	if pos >= p.segmentBytes && n > 0 {
+		if err = p.openSegment(offset); err != nil {
+			return 0, err
+		}
+		pos = 0
+	}
+
+	if _, err = p.log.Write(entry); err != nil {
+		return 0, errors.Wrap(err, "filelog: write log")
+	}
+	idx := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(idx, uint64(pos))
+	if _, err = p.index.Write(idx); err != nil {
+		return 0, errors.Wrap(err, "filelog: write index")
+	}
+
+	p.dirty = true
+	if p.syncPolicy == SyncAlways {
+		if err = p.syncLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	close(p.appended)
+	p.appended = make(chan struct{})
+	return offset, nil
+}
+
+// lockFile acquires an exclusive lock of the partition, so other processes can't append concurrently.
+func (p *partition) lockFile() (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(p.dir, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "filelog: lock partition")
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// openActiveSegment opens the last segment for appending unless it's already open.
+// Another process could have rolled a new segment since the last append.
+func (p *partition) openActiveSegment() error {
+	bases, err := p.segments()
+	if err != nil {
+		return err
+	}
+	var base int64
+	if len(bases) > 0 {
+		base = bases[len(bases)-1]
+	}
+	if p.log != nil && p.base == base {
+		return nil
+	}
+	return p.openSegment(base)
+}
+
+// openSegment opens or creates the segment's files for appending and repairs them.
+func (p *partition) openSegment(base int64) error {
+	if err := p.closeSegment(); err != nil {
+		return err
+	}
+	var err error
+	if p.log, err = os.OpenFile(p.segmentPath(base, ".log"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	if p.index, err = os.OpenFile(p.segmentPath(base, ".index"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		p.log.Close()
+		p.log = nil
+		return err
+	}
+	p.base = base
+	if err = p.repair(); err != nil {
+		p.log.Close()
+		p.index.Close()
+		p.log, p.index = nil, nil
+		return err
+	}
+	return nil
+}
+
+// repair truncates a partially written index entry and the log after the last indexed record,
+// e.g., a process crashed in the middle of an append. The files are opened with O_APPEND,
+// so the next append would be written after the partial entry and misalign the rest of the index.
+// It's called while the partition is locked.
+func (p *partition) repair() error {
+	indexInfo, err := p.index.Stat()
+	if err != nil {
+		return err
+	}
+	n := indexInfo.Size() / indexEntrySize
+	if indexInfo.Size() != n*indexEntrySize {
+		if err = p.index.Truncate(n * indexEntrySize); err != nil {
+			return errors.Wrap(err, "filelog: truncate index")
+		}
+	}
+
+	// The log ends with the last indexed record.
+	var end int64
+	if n > 0 {
+		idx := make([]byte, indexEntrySize)
+		if _, err = p.index.ReadAt(idx, (n-1)*indexEntrySize); err != nil {
+			return errors.Wrapf(err, "filelog: read index at %d", p.base+n-1)
+		}
+		pos := int64(binary.BigEndian.Uint64(idx))
+		header := make([]byte, recordHeaderSize)
+		if _, err = p.log.ReadAt(header, pos); err != nil {
+			return errors.Wrapf(err, "filelog: read record header at %d", p.base+n-1)
+		}
+		end = pos + recordHeaderSize + int64(binary.BigEndian.Uint32(header[4:8]))
+	}
+	logInfo, err := p.log.Stat()
+	if err != nil {
+		return err
+	}
+	if logInfo.Size() > end {
+		if err = p.log.Truncate(end); err != nil {
+			return errors.Wrap(err, "filelog: truncate log")
+		}
+	}
+	return nil
+}
+
+// closeSegment syncs and closes the active segment's files.
+func (p *partition) closeSegment() error {
+	if p.log == nil {
+		return nil
+	}
+	err := p.syncLocked()
+	p.log.Close()
+	p.index.Close()
+	p.log, p.index = nil, nil
+	return err
+}
+
+// sync flushes the appended records to disk.
+func (p *partition) sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.syncLocked()
+}
+
+// syncLocked flushes the log before the index while p.mu is held.
+func (p *partition) syncLocked() error {
+	if !p.dirty || p.log == nil {
+		return nil
+	}
+	if err := p.log.Sync(); err != nil {
+		return errors.Wrap(err, "filelog: sync log")
+	}
+	if err := p.index.Sync(); err != nil {
+		return errors.Wrap(err, "filelog: sync index")
+	}
+	p.dirty = false
+	return nil
+}
+
+// close syncs and closes the partition's files.
+func (p *partition) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closeSegment()
+}
+
+// nextOffset returns the offset of the record that will be appended next.
+func (p *partition) nextOffset() (int64, error) {
+	bases, err := p.segments()
+	if err != nil || len(bases) == 0 {
+		return 0, err
+	}
+	base := bases[len(bases)-1]
+	info, err := os.Stat(p.segmentPath(base, ".index"))
+	if os.IsNotExist(err) {
+		return base, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return base + info.Size()/indexEntrySize, nil
+}
+
+// oldestOffset returns the offset of the first record of the partition.
+func (p *partition) oldestOffset() (int64, error) {
+	bases, err := p.segments()
+	if err != nil || len(bases) == 0 {
+		return 0, err
+	}
+	return bases[0], nil
+}
+
+// offsetForTime returns the offset of the first record created at or after since.
+// Records are binary searched by their creation time which grows with offsets, though records appended
+// concurrently by different processes could be slightly out of order as in Kafka.
+// The offset following the last record is returned when there is no such record.
+func (p *partition) offsetForTime(since time.Time) (int64, error) {
+	oldest, err := p.oldestOffset()
+	if err != nil {
+		return 0, err
+	}
+	next, err := p.nextOffset()
+	if err != nil {
+		return 0, err
+	}
+
+	var seg *segmentReader
+	defer func() { seg.close() }()
+	lo, hi := oldest, next
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if seg == nil || mid < seg.base {
+			seg.close()
+			if seg, err = p.openSegmentReader(mid); err != nil {
+				return 0, err
+			}
+		}
+		r, err := seg.readAt(mid)
+		// The offset is in one of the next segments.
+		if err == errEndOfPartition {
+			seg.close()
+			if seg, err = p.openSegmentReader(mid); err != nil {
+				return 0, err
+			}
+			r, err = seg.readAt(mid)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if r.CreatedAt.Before(since) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// segmentReader reads records of a segment.
+type segmentReader struct {
+	base  int64
+	log   *os.File
+	index *os.File
+}
+
+// close closes the segment's files.
+func (s *segmentReader) close() {
+	if s == nil {
+		return
+	}
+	s.log.Close()
+	s.index.Close()
+}
+
+// openSegmentReader opens the segment which contains the offset.
+func (p *partition) openSegmentReader(offset int64) (*segmentReader, error) {
+	bases, err := p.segments()
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(bases), func(i int) bool { return bases[i] > offset }) - 1
+	if i < 0 {
+		return nil, errEndOfPartition
+	}
+
+	s := segmentReader{base: bases[i]}
+	if s.log, err = os.Open(p.segmentPath(s.base, ".log")); err != nil {
+		return nil, err
+	}
+	if s.index, err = os.Open(p.segmentPath(s.base, ".index")); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	return &s, nil
+}
+
+// readAt reads the record at the offset from the segment.
+// errEndOfPartition is returned when the segment has no record at the offset.
+func (s *segmentReader) readAt(offset int64) (*record, error) {
+	idx := make([]byte, indexEntrySize)
+	switch _, err := s.index.ReadAt(idx, (offset-s.base)*indexEntrySize); err {
+	case nil:
+	case io.EOF:
+		// The index entry isn't written yet.
+		return nil, errEndOfPartition
+	default:
+		return nil, errors.Wrapf(err, "filelog: read index at %d", offset)
+	}
+	pos := int64(binary.BigEndian.Uint64(idx))
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.log.ReadAt(header, pos); err != nil {
+		return nil, errors.Wrapf(err, "filelog: read record header at %d", offset)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+	if _, err := s.log.ReadAt(payload, pos+recordHeaderSize); err != nil {
+		return nil, errors.Wrapf(err, "filelog: read record at %d", offset)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[0:4]) {
+		return nil, errors.Errorf("filelog: record at %d is corrupted", offset)
+	}
+
+	r := record{}
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return nil, errors.Wrapf(err, "filelog: decode record at %d", offset)
+	}
+	return &r, nil
+}
@@ -0,0 +1,102 @@
+package filelog
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// PaymentService represents a file log service to store payment instructions.
+type PaymentService struct {
+	client *Client
+}
+
+// CreatePayment appends the payment to the partition of its account.
+func (s *PaymentService) CreatePayment(ctx context.Context, p *wallet.Payment) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	r := record{Value: b}
+	s.client.setMetadata(ctx, &r)
+	if p.Partition, p.SequenceID, err = s.client.payments.append(p.Account, &r); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "payment not created", "body", b, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "payment created", "partition", p.Partition, "offset", p.SequenceID, "body", b)
+	return nil
+}
+
+// CreateTransferPayments appends the payments created from the transfer request and then commits the offset
+// of the request. Unlike kafka.PaymentService it's not atomic: after a crash in between the request is read again
+// and some payments are appended twice. The duplicates have the same IDs, so accountantd skips them.
+func (s *PaymentService) CreateTransferPayments(ctx context.Context, t *wallet.Transfer, payments ...*wallet.Payment) error {
+	for _, p := range payments {
+		if err := s.CreatePayment(ctx, p); err != nil {
+			return err
+		}
+	}
+	if err := s.client.commit(s.client.transfers, t.Partition, t.SequenceID); err != nil {
+		return errors.Wrap(err, "commit transfer offset")
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "transfer payments created", "request", t.ID, "partition", t.Partition, "offset", t.SequenceID, "payments", len(payments))
+	return nil
+}
+
+// FromOffset returns a channel of payments from the given partition starting at offset.
+func (s *PaymentService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Payment, <-chan error) {
+	payments := make(chan *wallet.Payment)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the payments channel after read returns.
+		defer close(payments)
+
+		err := s.client.read(ctx, s.client.payments, partition, offset, func(offset int64, r *record) error {
+			p := wallet.Payment{}
+			if err := json.Unmarshal(r.Value, &p); err != nil {
+				return err
+			}
+			p.Partition = partition
+			p.SequenceID = offset
+			p.Meta = r.meta()
+
+			select {
+			case payments <- &p:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return payments, errc
+}
+
+// FromTime returns a channel of payments from the given partition starting at the first payment created at or after since.
+func (s *PaymentService) FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Payment, <-chan error) {
+	offset, err := s.client.payments.offsetForTime(partition, since)
+	if err != nil {
+		payments := make(chan *wallet.Payment)
+		close(payments)
+		errc := make(chan error, 1)
+		errc <- err
+		return payments, errc
+	}
+	return s.FromOffset(ctx, partition, offset)
+}
+
+// CommitOffset marks the payment at offset as processed, so FromOffset with wallet.OffsetCommitted resumes after it.
+// It requires the Client's group, see WithGroup.
+func (s *PaymentService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.commit(s.client.payments, partition, offset)
+}
@@ -0,0 +1,69 @@
+package filelog
+
+import (
+	"context"
+	"encoding/json"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// RejectionService represents a file log service to store payment rejections.
+type RejectionService struct {
+	client *Client
+}
+
+// CreateRejection appends the rejection to the partition of the payment's request ID,
+// so paymentd finds it in the partition with the same number as the transfer's partition.
+func (s *RejectionService) CreateRejection(ctx context.Context, rej *wallet.Rejection) error {
+	b, err := json.Marshal(rej)
+	if err != nil {
+		return err
+	}
+	r := record{Value: b}
+	s.client.setMetadata(ctx, &r)
+	if rej.Partition, rej.SequenceID, err = s.client.rejections.append(rej.Payment.RequestID, &r); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "rejection not created", "body", b, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "rejection created", "partition", rej.Partition, "offset", rej.SequenceID, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of rejections from the given partition starting at offset.
+func (s *RejectionService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Rejection, <-chan error) {
+	rejections := make(chan *wallet.Rejection)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the rejections channel after read returns.
+		defer close(rejections)
+
+		err := s.client.read(ctx, s.client.rejections, partition, offset, func(offset int64, r *record) error {
+			rej := wallet.Rejection{}
+			if err := json.Unmarshal(r.Value, &rej); err != nil {
+				return err
+			}
+			rej.Partition = partition
+			rej.SequenceID = offset
+
+			select {
+			case rejections <- &rej:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return rejections, errc
+}
+
+// CommitOffset marks the rejection at offset as processed, so FromOffset with wallet.OffsetCommitted resumes after it.
+// It requires the Client's group, see WithGroup.
+func (s *RejectionService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.commit(s.client.rejections, partition, offset)
+}
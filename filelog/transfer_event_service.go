@@ -0,0 +1,62 @@
+package filelog
+
+import (
+	"context"
+	"encoding/json"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// TransferEventService represents a file log service to store transfer status changes.
+type TransferEventService struct {
+	client *Client
+}
+
+// CreateEvent appends the transfer event to the partition of its request ID.
+func (s *TransferEventService) CreateEvent(ctx context.Context, e *wallet.TransferEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	r := record{Value: b}
+	s.client.setMetadata(ctx, &r)
+	if e.Partition, e.SequenceID, err = s.client.events.append(e.RequestID, &r); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "transfer event not created", "body", b, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "transfer event created", "partition", e.Partition, "offset", e.SequenceID, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of transfer events from the given partition starting at offset.
+func (s *TransferEventService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.TransferEvent, <-chan error) {
+	events := make(chan *wallet.TransferEvent)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the events channel after read returns.
+		defer close(events)
+
+		err := s.client.read(ctx, s.client.events, partition, offset, func(offset int64, r *record) error {
+			e := wallet.TransferEvent{}
+			if err := json.Unmarshal(r.Value, &e); err != nil {
+				return err
+			}
+			e.Partition = partition
+			e.SequenceID = offset
+
+			select {
+			case events <- &e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return events, errc
+}
@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// BalanceChangeService represents a Kafka service to store balance changes in a log-compacted topic.
+// The topic must have as many partitions as the payment topic, so an account's balance changes land
+// in the partition with the same number as the account's payments.
+type BalanceChangeService struct {
+	client *Client
+}
+
+// CreateBalanceChange persists a balance change encoded as JSON message keyed by account.
+// Kafka keeps at least the latest message of each key when it compacts the topic.
+func (s *BalanceChangeService) CreateBalanceChange(ctx context.Context, b *wallet.BalanceChange) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	s.client.logger.Log("level", "debug", "msg", "creating balance change", "body", v)
+
+	m := sarama.ProducerMessage{
+		Topic: s.client.copts.balanceTopic,
+		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
+		Key:   sarama.StringEncoder(b.Account),
+		Value: sarama.ByteEncoder(v),
+	}
+	partition, offset, err := s.client.producer.SendMessage(&m)
+	if err != nil {
+		s.client.logger.Log("level", "debug", "msg", "balance change not created", "topic", s.client.copts.balanceTopic, "body", v, "err", err)
+		return err
+	}
+	b.Partition = partition
+	b.SequenceID = offset
+
+	s.client.logger.Log("level", "debug", "msg", "balance change created", "partition", partition, "offset", offset, "body", v)
+	return nil
+}
+
+// FromOffset returns a channel of balance changes from the given partition starting at offset.
+func (s *BalanceChangeService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.BalanceChange, <-chan error) {
+	changes := make(chan *wallet.BalanceChange)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the changes channel after messages returns.
+		defer close(changes)
+
+		err := s.client.messages(ctx, s.client.copts.balanceTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
+			b := wallet.BalanceChange{}
+			if err := json.Unmarshal(m.Value, &b); err != nil {
+				return err
+			}
+			b.Partition = m.Partition
+			b.SequenceID = m.Offset
+
+			select {
+			case changes <- &b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return changes, errc
+}
+
+// Latest reads the partition up to its high water mark and returns the latest balance change of each account.
+// The last message of the partition is never removed by compaction, since it's the latest one of its key.
+func (s *BalanceChangeService) Latest(ctx context.Context, partition int32) (map[string]*wallet.BalanceChange, error) {
+	latest := make(map[string]*wallet.BalanceChange)
+	oldest, newest, err := s.client.OffsetRange(s.client.copts.balanceTopic, partition)
+	if err != nil {
+		return nil, err
+	}
+	if oldest == newest {
+		return latest, nil
+	}
+
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changes, errc := s.FromOffset(readCtx, partition, oldest)
+	for b := range changes {
+		latest[b.Account] = b
+		if b.SequenceID >= newest-1 {
+			cancel()
+		}
+	}
+	// Reading was stopped on the last change unless ctx is done.
+	if err = <-errc; err != nil && (err != context.Canceled || ctx.Err() != nil) {
+		return nil, err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "latest balance changes found", "partition", partition, "offset", newest-1, "accounts", len(latest))
+	return latest, nil
+}
@@ -9,7 +9,13 @@
 package kafka
 
 import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"sync"
+
 	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
 
 	wallet "github.com/marselester/distributed-payment"
 )
@@ -19,43 +25,117 @@ const (
 	DefaultTransferTopic = "wallet.transfer_request"
 	// DefaultPaymentTopic is a default topic where payments are emitted.
 	DefaultPaymentTopic = "wallet.payment"
+	// DefaultRejectionTopic is a default topic where payment rejections are emitted.
+	DefaultRejectionTopic = "wallet.payment_rejection"
+	// DefaultTransferEventTopic is a default topic where transfer status changes are emitted.
+	DefaultTransferEventTopic = "wallet.transfer_event"
+	// DefaultBalanceTopic is a default log-compacted topic where balance changes are emitted.
+	DefaultBalanceTopic = "wallet.balance"
+	// DefaultDeadLetterTopic is a default topic where messages which couldn't be processed are forwarded.
+	DefaultDeadLetterTopic = "wallet.dead_letter"
 )
 
 // Client represents a client to the underlying Kafka commit log.
 type Client struct {
-	Transfer wallet.TransferService
-	Payment  wallet.PaymentService
+	Transfer  wallet.TransferService
+	Payment   wallet.PaymentService
+	Rejection wallet.RejectionService
+	Event     wallet.TransferEventService
+	// BalanceChange is used by accountantd to publish balances and restore them.
+	BalanceChange wallet.BalanceChangeService
 
-	logger   wallet.Logger
+	logger wallet.Logger
+	// status is used to detect retried transfer requests, see WithTransferStatusService.
+	status wallet.TransferStatusService
+	// conn is a connection to the cluster shared by the consumer and the producer.
+	conn     sarama.Client
 	consumer sarama.Consumer
 	producer sarama.SyncProducer
+	config   *sarama.Config
+	// offsets commits offsets of the consumer group when partitions are read without Consume.
+	offsets sarama.OffsetManager
+	// mu guards partition offset managers which are created on demand, see partitionOffsets,
+	// and messages delivered to readers which aren't processed yet, see Processed.
+	mu         sync.Mutex
+	pOffsets   map[topicPartition]sarama.PartitionOffsetManager
+	deliveries map[topicPartition]delivery
+	// txProducer writes messages in transactions, it has its own connection,
+	// because transactions can't be mixed with the messages of the producer.
+	txConn     sarama.Client
+	txProducer sarama.SyncProducer
+	// txMu makes sure only one transaction is in progress.
+	txMu sync.Mutex
 
 	copts connOption
 }
 
 // connOption holds connection settings.
 type connOption struct {
-	brokers       []string
-	transferTopic string
-	paymentTopic  string
+	brokers        []string
+	transferTopic  string
+	paymentTopic   string
+	rejectionTopic string
+	eventTopic     string
+	balanceTopic   string
+	// errorHandler decides whether to stop or skip a message which couldn't be processed.
+	errorHandler ErrorHandler
+	// deadLetterTopic is where failed messages are forwarded instead of being passed to errorHandler.
+	deadLetterTopic string
+	// maxAttempts is how many times a message is processed before it's considered failed.
+	maxAttempts int
+	// group is the consumer group which commits offsets and which Consume joins.
+	group string
+	// codec encodes transfers and payments, see WithCodec.
+	codec Codec
+	// serviceName is put into headers of transfers and payments to tell which service created them.
+	serviceName string
+	// transactionalID identifies the transactional producer across restarts, see WithTransactionalID.
+	transactionalID string
+	// metrics is where consumption progress is reported, see WithMetrics.
+	metrics *Metrics
+	// topics are verified when the Client is opened, missing ones are created if createTopics is set.
+	topics       []Topic
+	createTopics bool
 }
 
 // NewClient returns a new Client which provides you with
-// transfer and payment services based on Kafka.
+// transfer, payment, rejection, transfer event and balance change services based on Kafka.
 func NewClient(options ...ConfigOption) *Client {
 	c := Client{
 		logger: &wallet.NoopLogger{},
 		copts: connOption{
-			transferTopic: DefaultTransferTopic,
-			paymentTopic:  DefaultPaymentTopic,
+			transferTopic:  DefaultTransferTopic,
+			paymentTopic:   DefaultPaymentTopic,
+			rejectionTopic: DefaultRejectionTopic,
+			eventTopic:     DefaultTransferEventTopic,
+			balanceTopic:   DefaultBalanceTopic,
+			errorHandler:   StopOnError,
+			maxAttempts:    1,
+			codec:          JSONCodec{},
+			serviceName:    filepath.Base(os.Args[0]),
 		},
+		config:     sarama.NewConfig(),
+		pOffsets:   make(map[topicPartition]sarama.PartitionOffsetManager),
+		deliveries: make(map[topicPartition]delivery),
 	}
+	// Kafka 0.11 introduced message headers which are used to describe dead letters.
+	c.config.Version = sarama.V0_11_0_0
+	c.config.Producer.Return.Successes = true
+	// A new consumer group reads partitions from the beginning, see Consume.
+	c.config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	// Messages of aborted transactions are never read, see CreateTransferPayments.
+	c.config.Consumer.IsolationLevel = sarama.ReadCommitted
 	c.Transfer = &TransferService{client: &c}
 	c.Payment = &PaymentService{client: &c}
+	c.Rejection = &RejectionService{client: &c}
+	c.Event = &TransferEventService{client: &c}
+	c.BalanceChange = &BalanceChangeService{client: &c}
 
 	for _, opt := range options {
 		opt(&c)
 	}
+	// Dead letters are reinjected to their original partitions regardless of the partitioner, see Reinject.
+	c.config.Producer.Partitioner = withPinnedPartitions(c.config.Producer.Partitioner)
 	return &c
 }
 
@@ -76,32 +156,263 @@ func WithBrokers(brokers ...string) ConfigOption {
 	}
 }
 
-// Open creates Kafka consumer and producer.
+// WithGroup sets the consumer group which commits offsets of processed messages, see CommitOffset of the services.
+// Consume joins the group to get partitions assigned.
+func WithGroup(group string) ConfigOption {
+	return func(c *Client) {
+		c.copts.group = group
+	}
+}
+
+// WithServiceName sets a name of the service which produces transfers and payments, e.g., paymentd.
+// It's kept in the messages' headers and defaults to the program name.
+func WithServiceName(name string) ConfigOption {
+	return func(c *Client) {
+		c.copts.serviceName = name
+	}
+}
+
+// WithCodec sets how transfers and payments are encoded, e.g., ProtobufCodec (JSONCodec by default).
+// Messages are decoded according to their headers regardless of the codec,
+// so the codec can be changed without breaking consumers which replay old offsets.
+func WithCodec(codec Codec) ConfigOption {
+	return func(c *Client) {
+		c.copts.codec = codec
+	}
+}
+
+// WithTransactionalID enables transactions, e.g., PaymentService.CreateTransferPayments.
+// The ID must be unique per producer instance and stay the same after a restart,
+// so Kafka could abort the transaction the instance left unfinished and fence off its zombie.
+func WithTransactionalID(id string) ConfigOption {
+	return func(c *Client) {
+		c.copts.transactionalID = id
+	}
+}
+
+// WithTLS enables TLS to connect to the brokers, e.g., a production cluster.
+func WithTLS(cfg *tls.Config) ConfigOption {
+	return func(c *Client) {
+		c.config.Net.TLS.Enable = true
+		c.config.Net.TLS.Config = cfg
+	}
+}
+
+// WithSASL enables SASL/PLAIN authentication with the user and the password.
+// Make sure TLS is enabled as well, since the password is sent as is.
+func WithSASL(user, password string) ConfigOption {
+	return func(c *Client) {
+		c.config.Net.SASL.Enable = true
+		c.config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		c.config.Net.SASL.User = user
+		c.config.Net.SASL.Password = password
+	}
+}
+
+// WithRequiredAcks sets how many replica acknowledgements the producer waits for,
+// e.g., sarama.WaitForAll (acks=all). By default only the leader has to acknowledge a message.
+// Transactions always wait for all in-sync replicas.
+func WithRequiredAcks(acks sarama.RequiredAcks) ConfigOption {
+	return func(c *Client) {
+		c.config.Producer.RequiredAcks = acks
+	}
+}
+
+// WithCompression sets how the producer compresses messages, e.g., sarama.CompressionSnappy.
+func WithCompression(codec sarama.CompressionCodec) ConfigOption {
+	return func(c *Client) {
+		c.config.Producer.Compression = codec
+	}
+}
+
+// WithRetries sets how many times the producer retries to send a message (defaults to 3).
+func WithRetries(n int) ConfigOption {
+	return func(c *Client) {
+		c.config.Producer.Retry.Max = n
+	}
+}
+
+// WithClientID sets a client ID which brokers use in logs and quotas (defaults to "sarama").
+func WithClientID(id string) ConfigOption {
+	return func(c *Client) {
+		c.config.ClientID = id
+	}
+}
+
+// WithKafkaVersion sets a version of the brokers, so newer protocol features can be used.
+// The services require at least Kafka 0.11 which introduced message headers and transactions.
+func WithKafkaVersion(v sarama.KafkaVersion) ConfigOption {
+	return func(c *Client) {
+		c.config.Version = v
+	}
+}
+
+// WithErrorHandler sets a policy for messages which couldn't be processed, e.g., SkipOnError.
+// By default the services stop reading a partition and return MessageError, see StopOnError.
+func WithErrorHandler(h ErrorHandler) ConfigOption {
+	return func(c *Client) {
+		c.copts.errorHandler = h
+	}
+}
+
+// WithDeadLetterTopic forwards messages which couldn't be processed to the topic, e.g., DefaultDeadLetterTopic.
+// The original topic, partition, offset and the error are kept in the message headers,
+// so the message could be inspected and re-injected once it's fixed, see DeadLetters and Reinject.
+func WithDeadLetterTopic(topic string) ConfigOption {
+	return func(c *Client) {
+		c.copts.deadLetterTopic = topic
+	}
+}
+
+// WithMaxAttempts sets how many times a message is processed before it's considered failed (defaults to 1).
+func WithMaxAttempts(n int) ConfigOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.copts.maxAttempts = n
+		}
+	}
+}
+
+// WithMetrics reports high water mark, offset, lag, throughput and processing latency
+// of the partitions being read, e.g., NewPrometheusMetrics. A message is reported once its offset is committed,
+// readers which don't commit offsets should call Processed.
+func WithMetrics(m *Metrics) ConfigOption {
+	return func(c *Client) {
+		c.copts.metrics = m
+	}
+}
+
+// WithTopics makes Open verify that the topics exist with the expected number of partitions,
+// cleanup policy and retention, e.g., DefaultTopics.
+func WithTopics(topics ...Topic) ConfigOption {
+	return func(c *Client) {
+		c.copts.topics = topics
+	}
+}
+
+// WithCreateTopics makes Open create the missing topics of WithTopics using the cluster admin API.
+func WithCreateTopics(create bool) ConfigOption {
+	return func(c *Client) {
+		c.copts.createTopics = create
+	}
+}
+
+// WithTransferStatusService makes TransferService detect duplicate request IDs.
+// A transfer is recorded as accepted in the status service once it's stored in Kafka,
+// so a retried request returns wallet.ErrTransferExists instead of writing another message.
+func WithTransferStatusService(s wallet.TransferStatusService) ConfigOption {
+	return func(c *Client) {
+		c.status = s
+	}
+}
+
+// Open connects to Kafka, verifies the topics (see WithTopics), and creates consumer and producer.
 // Make sure you call Close to clean up resources.
 func (c *Client) Open() error {
+	if !c.config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return errors.Errorf("kafka: version %s is older than 0.11", c.config.Version)
+	}
+
 	var err error
-	c.consumer, err = sarama.NewConsumer(c.copts.brokers, nil)
+	c.conn, err = sarama.NewClient(c.copts.brokers, c.config)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "connection failed", "err", err)
+		return err
+	}
+	c.logger.Log("level", "debug", "msg", "connected")
+
+	if err = c.checkTopics(); err != nil {
+		return err
+	}
+
+	c.consumer, err = sarama.NewConsumerFromClient(c.conn)
 	if err != nil {
 		c.logger.Log("level", "debug", "msg", "consumer not created", "err", err)
 		return err
 	}
 	c.logger.Log("level", "debug", "msg", "consumer created")
 
-	c.producer, err = sarama.NewSyncProducer(c.copts.brokers, nil)
+	c.producer, err = sarama.NewSyncProducerFromClient(c.conn)
 	if err != nil {
 		c.logger.Log("level", "debug", "msg", "producer not created", "err", err)
 		return err
 	}
 	c.logger.Log("level", "debug", "msg", "producer created")
+
+	if c.copts.group != "" {
+		c.offsets, err = sarama.NewOffsetManagerFromClient(c.copts.group, c.conn)
+		if err != nil {
+			c.logger.Log("level", "debug", "msg", "offset manager not created", "group", c.copts.group, "err", err)
+			return err
+		}
+		c.logger.Log("level", "debug", "msg", "offset manager created", "group", c.copts.group)
+	}
+
+	if c.copts.transactionalID == "" {
+		return nil
+	}
+	txConfig := *c.config
+	txConfig.Producer.Transaction.ID = c.copts.transactionalID
+	txConfig.Producer.Idempotent = true
+	txConfig.Producer.RequiredAcks = sarama.WaitForAll
+	txConfig.Net.MaxOpenRequests = 1
+	c.txConn, err = sarama.NewClient(c.copts.brokers, &txConfig)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "transactional connection failed", "err", err)
+		return err
+	}
+	c.txProducer, err = sarama.NewSyncProducerFromClient(c.txConn)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "transactional producer not created", "transactional_id", c.copts.transactionalID, "err", err)
+		return err
+	}
+	c.logger.Log("level", "debug", "msg", "transactional producer created", "transactional_id", c.copts.transactionalID)
 	return nil
 }
 
+// Partitions returns partition numbers of the topic, e.g., DefaultTransferEventTopic.
+func (c *Client) Partitions(topic string) ([]int32, error) {
+	return c.conn.Partitions(topic)
+}
+
+// OffsetRange returns the oldest available offset of the partition and
+// the offset of the message that will be appended next.
+func (c *Client) OffsetRange(topic string, partition int32) (oldest, newest int64, err error) {
+	if oldest, err = c.conn.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+		return 0, 0, err
+	}
+	if newest, err = c.conn.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+		return 0, 0, err
+	}
+	return oldest, newest, nil
+}
+
 // Close shuts down the producer and waits for any buffered messages to be flushed.
-// It also closes the consumer.
+// It also flushes committed offsets and closes the consumer and the connection.
 func (c *Client) Close() {
+	if c.offsets != nil {
+		c.mu.Lock()
+		for tp, pom := range c.pOffsets {
+			pom.Close()
+			delete(c.pOffsets, tp)
+		}
+		c.mu.Unlock()
+		c.offsets.Close()
+		c.logger.Log("level", "debug", "msg", "offset manager closed")
+	}
+
 	c.consumer.Close()
 	c.logger.Log("level", "debug", "msg", "consumer closed")
 
 	c.producer.Close()
 	c.logger.Log("level", "debug", "msg", "producer closed")
+
+	if c.txProducer != nil {
+		c.txProducer.Close()
+		c.txConn.Close()
+		c.logger.Log("level", "debug", "msg", "transactional producer closed")
+	}
+
+	c.conn.Close()
+	c.logger.Log("level", "debug", "msg", "connection closed")
 }
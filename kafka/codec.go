@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// Headers which tell how a message's value is encoded.
+const (
+	headerContentType   = "content_type"
+	headerSchemaVersion = "schema_version"
+)
+
+// Schema versions of transfers and payments. A message without schema version header is decoded as SchemaV1.
+const (
+	// SchemaV1 is a JSON message created before currencies were introduced.
+	SchemaV1 = 1
+	// SchemaV2 has a currency of the amount, a payment also has a counterparty and a reversed payment ID.
+	SchemaV2 = 2
+	// SchemaVersion is a version of messages which are written by the services.
+	SchemaVersion = SchemaV2
+)
+
+// ErrUnsupportedVersion is returned when a codec can't decode a message of the schema version.
+var ErrUnsupportedVersion = errors.New("kafka: unsupported schema version")
+
+// Codec encodes transfers and payments into Kafka messages' values and decodes them back.
+// A decoder must support every schema version the codec has ever written, so old offsets can be replayed.
+type Codec interface {
+	// ContentType identifies the codec in a message's header, e.g., "application/json".
+	ContentType() string
+	EncodeTransfer(t *wallet.Transfer) ([]byte, error)
+	DecodeTransfer(b []byte, version int, t *wallet.Transfer) error
+	EncodePayment(p *wallet.Payment) ([]byte, error)
+	DecodePayment(b []byte, version int, p *wallet.Payment) error
+}
+
+// codecs are the codecs known to the readers, so a topic can have messages in different encodings,
+// e.g., when a writer switched from JSON to Protocol Buffers.
+var codecs = map[string]Codec{
+	JSONCodec{}.ContentType():     JSONCodec{},
+	ProtobufCodec{}.ContentType(): ProtobufCodec{},
+	AvroCodec{}.ContentType():     AvroCodec{},
+}
+
+// CodecByName returns the codec by its short name: json, protobuf or avro.
+// It helps to choose a codec with a command line flag.
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSONCodec{}, nil
+	case "protobuf":
+		return ProtobufCodec{}, nil
+	case "avro":
+		return AvroCodec{}, nil
+	}
+	return nil, errors.Errorf("kafka: unknown codec %q", name)
+}
+
+// codecHeaders returns headers which describe the message encoded by the codec.
+func codecHeaders(codec Codec) []sarama.RecordHeader {
+	return []sarama.RecordHeader{
+		{Key: []byte(headerContentType), Value: []byte(codec.ContentType())},
+		{Key: []byte(headerSchemaVersion), Value: []byte(strconv.Itoa(SchemaVersion))},
+	}
+}
+
+// messageCodec returns the codec and the schema version of the message based on its headers.
+// Messages without headers are JSON of the first schema version.
+func messageCodec(m *sarama.ConsumerMessage) (Codec, int, error) {
+	var codec Codec = JSONCodec{}
+	version := SchemaV1
+	for _, h := range m.Headers {
+		switch string(h.Key) {
+		case headerContentType:
+			var ok bool
+			if codec, ok = codecs[string(h.Value)]; !ok {
+				return nil, 0, errors.Errorf("kafka: unknown content type %q", h.Value)
+			}
+		case headerSchemaVersion:
+			v, err := strconv.Atoi(string(h.Value))
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "kafka: schema version header")
+			}
+			version = v
+		}
+	}
+	return codec, version, nil
+}
+
+// decodeTransfer decodes the transfer from the message using the codec and the schema version of the message.
+// The transfer's metadata is set from the message headers.
+func decodeTransfer(m *sarama.ConsumerMessage, t *wallet.Transfer) error {
+	codec, version, err := messageCodec(m)
+	if err != nil {
+		return err
+	}
+	t.Meta = messageMetadata(m)
+	return codec.DecodeTransfer(m.Value, version, t)
+}
+
+// decodePayment decodes the payment from the message using the codec and the schema version of the message.
+// The payment's metadata is set from the message headers.
+func decodePayment(m *sarama.ConsumerMessage, p *wallet.Payment) error {
+	codec, version, err := messageCodec(m)
+	if err != nil {
+		return err
+	}
+	p.Meta = messageMetadata(m)
+	return codec.DecodePayment(m.Value, version, p)
+}
+
+// JSONCodec encodes messages as JSON. It is the default codec.
+type JSONCodec struct{}
+
+// ContentType returns "application/json".
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// EncodeTransfer encodes the transfer as JSON.
+func (JSONCodec) EncodeTransfer(t *wallet.Transfer) ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// DecodeTransfer decodes JSON of all schema versions, since the fields were only added.
+// A transfer of the first version gets the default currency.
+func (JSONCodec) DecodeTransfer(b []byte, version int, t *wallet.Transfer) error {
+	if version > SchemaVersion {
+		return ErrUnsupportedVersion
+	}
+	if err := json.Unmarshal(b, t); err != nil {
+		return err
+	}
+	if version == SchemaV1 && t.Currency == "" {
+		t.Currency = wallet.DefaultCurrency
+	}
+	return nil
+}
+
+// EncodePayment encodes the payment as JSON.
+func (JSONCodec) EncodePayment(p *wallet.Payment) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// DecodePayment decodes JSON of all schema versions, since the fields were only added.
+// A payment of the first version gets the default currency.
+func (JSONCodec) DecodePayment(b []byte, version int, p *wallet.Payment) error {
+	if version > SchemaVersion {
+		return ErrUnsupportedVersion
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return err
+	}
+	if version == SchemaV1 && p.Currency == "" {
+		p.Currency = wallet.DefaultCurrency
+	}
+	return nil
+}
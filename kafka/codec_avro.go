@@ -0,0 +1,141 @@
+package kafka
+
+import (
+	"github.com/linkedin/goavro/v2"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// Avro schemas of the second schema version. Amounts are decimal strings to keep their precision.
+const (
+	avroTransferSchema = `{
+		"type": "record",
+		"name": "Transfer",
+		"namespace": "wallet",
+		"fields": [
+			{"name": "request_id", "type": "string"},
+			{"name": "from", "type": "string"},
+			{"name": "amount", "type": "string"},
+			{"name": "currency", "type": "string"},
+			{"name": "to", "type": "string"}
+		]
+	}`
+	avroPaymentSchema = `{
+		"type": "record",
+		"name": "Payment",
+		"namespace": "wallet",
+		"fields": [
+			{"name": "id", "type": "string"},
+			{"name": "request_id", "type": "string"},
+			{"name": "account", "type": "string"},
+			{"name": "direction", "type": "string"},
+			{"name": "amount", "type": "string"},
+			{"name": "currency", "type": "string"},
+			{"name": "counterparty", "type": "string", "default": ""},
+			{"name": "reverses", "type": "string", "default": ""}
+		]
+	}`
+)
+
+var (
+	avroTransfer = mustAvroCodec(avroTransferSchema)
+	avroPayment  = mustAvroCodec(avroPaymentSchema)
+)
+
+// mustAvroCodec returns Avro codec of the schema and panics if the schema is invalid.
+func mustAvroCodec(schema string) *goavro.Codec {
+	c, err := goavro.NewCodec(schema)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// AvroCodec encodes messages as Avro binary. The writer's schema isn't embedded into a message,
+// the schema version header tells which schema a message was written with.
+type AvroCodec struct{}
+
+// ContentType returns "avro/binary".
+func (AvroCodec) ContentType() string {
+	return "avro/binary"
+}
+
+// EncodeTransfer encodes the transfer as Transfer record.
+func (AvroCodec) EncodeTransfer(t *wallet.Transfer) ([]byte, error) {
+	return avroTransfer.BinaryFromNative(nil, map[string]interface{}{
+		"request_id": t.ID,
+		"from":       t.From,
+		"amount":     t.Amount.Text('f'),
+		"currency":   t.Currency,
+		"to":         t.To,
+	})
+}
+
+// DecodeTransfer decodes Transfer record. Avro was introduced in the second schema version.
+func (AvroCodec) DecodeTransfer(b []byte, version int, t *wallet.Transfer) error {
+	if version != SchemaV2 {
+		return ErrUnsupportedVersion
+	}
+	r, err := avroRecord(avroTransfer, b)
+	if err != nil {
+		return err
+	}
+	t.ID = r["request_id"]
+	t.From = r["from"]
+	t.Currency = r["currency"]
+	t.To = r["to"]
+	return t.Amount.UnmarshalText([]byte(r["amount"]))
+}
+
+// EncodePayment encodes the payment as Payment record.
+func (AvroCodec) EncodePayment(p *wallet.Payment) ([]byte, error) {
+	return avroPayment.BinaryFromNative(nil, map[string]interface{}{
+		"id":           p.ID,
+		"request_id":   p.RequestID,
+		"account":      p.Account,
+		"direction":    p.Direction,
+		"amount":       p.Amount.Text('f'),
+		"currency":     p.Currency,
+		"counterparty": p.Counterparty,
+		"reverses":     p.Reverses,
+	})
+}
+
+// DecodePayment decodes Payment record. Avro was introduced in the second schema version.
+func (AvroCodec) DecodePayment(b []byte, version int, p *wallet.Payment) error {
+	if version != SchemaV2 {
+		return ErrUnsupportedVersion
+	}
+	r, err := avroRecord(avroPayment, b)
+	if err != nil {
+		return err
+	}
+	p.ID = r["id"]
+	p.RequestID = r["request_id"]
+	p.Account = r["account"]
+	p.Direction = r["direction"]
+	p.Currency = r["currency"]
+	p.Counterparty = r["counterparty"]
+	p.Reverses = r["reverses"]
+	return p.Amount.UnmarshalText([]byte(r["amount"]))
+}
+
+// avroRecord decodes a record which has only string fields.
+func avroRecord(codec *goavro.Codec, b []byte) (map[string]string, error) {
+	native, _, err := codec.NativeFromBinary(b)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("kafka: avro %T is not a record", native)
+	}
+	r := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if r[k], ok = v.(string); !ok {
+			return nil, errors.Errorf("kafka: avro field %s is %T, not a string", k, v)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// Field numbers of Transfer message, see wallet.proto.
+const (
+	pbTransferID       protowire.Number = 1
+	pbTransferFrom     protowire.Number = 2
+	pbTransferAmount   protowire.Number = 3
+	pbTransferCurrency protowire.Number = 4
+	pbTransferTo       protowire.Number = 5
+)
+
+// Field numbers of Payment message, see wallet.proto.
+const (
+	pbPaymentID           protowire.Number = 1
+	pbPaymentRequestID    protowire.Number = 2
+	pbPaymentAccount      protowire.Number = 3
+	pbPaymentDirection    protowire.Number = 4
+	pbPaymentAmount       protowire.Number = 5
+	pbPaymentCurrency     protowire.Number = 6
+	pbPaymentCounterparty protowire.Number = 7
+	pbPaymentReverses     protowire.Number = 8
+)
+
+// ProtobufCodec encodes messages as Protocol Buffers, the schema is described in wallet.proto.
+// The messages have only string fields (an amount is a decimal string), so they are encoded
+// without generated code. Unknown fields are skipped, therefore newer messages can be decoded.
+type ProtobufCodec struct{}
+
+// ContentType returns "application/x-protobuf".
+func (ProtobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+// EncodeTransfer encodes the transfer as Transfer message.
+func (ProtobufCodec) EncodeTransfer(t *wallet.Transfer) ([]byte, error) {
+	var b []byte
+	b = appendPBString(b, pbTransferID, t.ID)
+	b = appendPBString(b, pbTransferFrom, t.From)
+	b = appendPBString(b, pbTransferAmount, t.Amount.Text('f'))
+	b = appendPBString(b, pbTransferCurrency, t.Currency)
+	b = appendPBString(b, pbTransferTo, t.To)
+	return b, nil
+}
+
+// DecodeTransfer decodes Transfer message. Protocol Buffers were introduced in the second schema version.
+func (ProtobufCodec) DecodeTransfer(b []byte, version int, t *wallet.Transfer) error {
+	if version < SchemaV2 || version > SchemaVersion {
+		return ErrUnsupportedVersion
+	}
+	return consumePBStrings(b, func(num protowire.Number, v string) error {
+		switch num {
+		case pbTransferID:
+			t.ID = v
+		case pbTransferFrom:
+			t.From = v
+		case pbTransferAmount:
+			return t.Amount.UnmarshalText([]byte(v))
+		case pbTransferCurrency:
+			t.Currency = v
+		case pbTransferTo:
+			t.To = v
+		}
+		return nil
+	})
+}
+
+// EncodePayment encodes the payment as Payment message.
+func (ProtobufCodec) EncodePayment(p *wallet.Payment) ([]byte, error) {
+	var b []byte
+	b = appendPBString(b, pbPaymentID, p.ID)
+	b = appendPBString(b, pbPaymentRequestID, p.RequestID)
+	b = appendPBString(b, pbPaymentAccount, p.Account)
+	b = appendPBString(b, pbPaymentDirection, p.Direction)
+	b = appendPBString(b, pbPaymentAmount, p.Amount.Text('f'))
+	b = appendPBString(b, pbPaymentCurrency, p.Currency)
+	b = appendPBString(b, pbPaymentCounterparty, p.Counterparty)
+	b = appendPBString(b, pbPaymentReverses, p.Reverses)
+	return b, nil
+}
+
+// DecodePayment decodes Payment message. Protocol Buffers were introduced in the second schema version.
+func (ProtobufCodec) DecodePayment(b []byte, version int, p *wallet.Payment) error {
+	if version < SchemaV2 || version > SchemaVersion {
+		return ErrUnsupportedVersion
+	}
+	return consumePBStrings(b, func(num protowire.Number, v string) error {
+		switch num {
+		case pbPaymentID:
+			p.ID = v
+		case pbPaymentRequestID:
+			p.RequestID = v
+		case pbPaymentAccount:
+			p.Account = v
+		case pbPaymentDirection:
+			p.Direction = v
+		case pbPaymentAmount:
+			return p.Amount.UnmarshalText([]byte(v))
+		case pbPaymentCurrency:
+			p.Currency = v
+		case pbPaymentCounterparty:
+			p.Counterparty = v
+		case pbPaymentReverses:
+			p.Reverses = v
+		}
+		return nil
+	})
+}
+
+// appendPBString appends a string field to the message unless the string is empty (proto3 default value).
+func appendPBString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// consumePBStrings calls f with a number and a value of each string field of the message.
+// Fields of other types are skipped.
+func consumePBStrings(b []byte, f func(num protowire.Number, v string) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeString(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package kafka_test
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/kafka"
+)
+
+func TestCodec_Payment(t *testing.T) {
+	amount, _, err := apd.NewFromString("0.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := wallet.Payment{
+		ID:           "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:outgoing:Alice",
+		RequestID:    "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		Account:      "Alice",
+		Direction:    "outgoing",
+		Amount:       *amount,
+		Currency:     "USD",
+		Counterparty: "Bob",
+	}
+
+	codecs := []kafka.Codec{kafka.JSONCodec{}, kafka.ProtobufCodec{}, kafka.AvroCodec{}}
+	for _, codec := range codecs {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			b, err := codec.EncodePayment(&want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := wallet.Payment{}
+			if err = codec.DecodePayment(b, kafka.SchemaVersion, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != want.ID || got.RequestID != want.RequestID || got.Account != want.Account ||
+				got.Direction != want.Direction || got.Amount.Cmp(&want.Amount) != 0 || got.Currency != want.Currency ||
+				got.Counterparty != want.Counterparty || got.Reverses != want.Reverses {
+				t.Errorf("DecodePayment() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONCodec_DecodeTransfer(t *testing.T) {
+	b := []byte(`{"request_id":"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11","from":"Alice","amount":"0.5","to":"Bob"}`)
+
+	got := wallet.Transfer{}
+	if err := (kafka.JSONCodec{}).DecodeTransfer(b, kafka.SchemaV1, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Currency != wallet.DefaultCurrency {
+		t.Errorf("DecodeTransfer() currency = %q, want %q", got.Currency, wallet.DefaultCurrency)
+	}
+
+	if err := (kafka.JSONCodec{}).DecodeTransfer(b, kafka.SchemaVersion+1, &got); err != kafka.ErrUnsupportedVersion {
+		t.Errorf("DecodeTransfer() error = %v, want %v", err, kafka.ErrUnsupportedVersion)
+	}
+}
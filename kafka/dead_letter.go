@@ -0,0 +1,147 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// Headers of a dead letter which describe where the message came from and why it failed.
+const (
+	headerTopic     = "dead_letter.topic"
+	headerPartition = "dead_letter.partition"
+	headerOffset    = "dead_letter.offset"
+	headerError     = "dead_letter.error"
+)
+
+// DeadLetter is a message which couldn't be processed and was forwarded to the dead letter topic.
+type DeadLetter struct {
+	// Topic, Partition and Offset tell where the message was originally stored.
+	Topic     string
+	Partition int32
+	Offset    int64
+	// Err is why the message couldn't be processed.
+	Err   string
+	Key   []byte
+	Value []byte
+	// Headers are the original message's headers, e.g., its content type and schema version.
+	Headers []sarama.RecordHeader
+	// DeadLetterPartition is a number of a partition of the dead letter topic where the message was forwarded.
+	DeadLetterPartition int32
+	// SequenceID is an offset of the message in the dead letter topic.
+	SequenceID int64
+}
+
+// forward sends the failed message to the dead letter topic keeping its key, value and headers intact.
+func (c *Client) forward(m *sarama.ConsumerMessage, cause error) error {
+	dm := sarama.ProducerMessage{
+		Topic: c.copts.deadLetterTopic,
+		Key:   sarama.ByteEncoder(m.Key),
+		Value: sarama.ByteEncoder(m.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(headerTopic), Value: []byte(m.Topic)},
+			{Key: []byte(headerPartition), Value: []byte(strconv.FormatInt(int64(m.Partition), 10))},
+			{Key: []byte(headerOffset), Value: []byte(strconv.FormatInt(m.Offset, 10))},
+			{Key: []byte(headerError), Value: []byte(cause.Error())},
+		},
+	}
+	for _, h := range m.Headers {
+		dm.Headers = append(dm.Headers, *h)
+	}
+	partition, offset, err := c.producer.SendMessage(&dm)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "dead letter not forwarded", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "err", err)
+		return err
+	}
+
+	c.logger.Log("level", "debug", "msg", "dead letter forwarded", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "dead_letter_partition", partition, "dead_letter_offset", offset)
+	return nil
+}
+
+// DeadLetters returns a channel of messages from the given partition of the dead letter topic starting at offset.
+func (c *Client) DeadLetters(ctx context.Context, partition int32, offset int64) (<-chan *DeadLetter, <-chan error) {
+	letters := make(chan *DeadLetter)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the letters channel after messages returns.
+		defer close(letters)
+
+		err := c.messages(ctx, c.copts.deadLetterTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
+			l := DeadLetter{
+				Key:                 m.Key,
+				Value:               m.Value,
+				DeadLetterPartition: m.Partition,
+				SequenceID:          m.Offset,
+			}
+			if err := l.parseHeaders(m.Headers); err != nil {
+				return err
+			}
+
+			select {
+			case letters <- &l:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return letters, errc
+}
+
+// parseHeaders sets the original topic, partition, offset and the error of the dead letter.
+func (l *DeadLetter) parseHeaders(headers []*sarama.RecordHeader) error {
+	var err error
+	for _, h := range headers {
+		switch string(h.Key) {
+		case headerTopic:
+			l.Topic = string(h.Value)
+		case headerPartition:
+			var p int64
+			p, err = strconv.ParseInt(string(h.Value), 10, 32)
+			l.Partition = int32(p)
+		case headerOffset:
+			l.Offset, err = strconv.ParseInt(string(h.Value), 10, 64)
+		case headerError:
+			l.Err = string(h.Value)
+		default:
+			l.Headers = append(l.Headers, *h)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "dead letter header %s", h.Key)
+		}
+	}
+	if l.Topic == "" {
+		return errors.New("dead letter has no original topic")
+	}
+	return nil
+}
+
+// Reinject sends the dead letter's value back to the original partition with the same key and headers,
+// so it's decoded the same way. The partition is set explicitly, because the partitioner could have
+// been changed since, see WithPartitioner. The value is supposed to be fixed by then.
+// The new partition and offset of the message are returned.
+func (c *Client) Reinject(ctx context.Context, l *DeadLetter) (int32, int64, error) {
+	m := sarama.ProducerMessage{
+		Topic:     l.Topic,
+		Partition: l.Partition,
+		Key:       sarama.ByteEncoder(l.Key),
+		Value:     sarama.ByteEncoder(l.Value),
+		Headers:   l.Headers,
+		Metadata:  pinned{},
+	}
+	partition, offset, err := c.producer.SendMessage(&m)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "dead letter not reinjected", "topic", l.Topic, "partition", l.Partition, "offset", l.Offset, "err", err)
+		return 0, 0, err
+	}
+
+	c.logger.Log("level", "debug", "msg", "dead letter reinjected", "topic", l.Topic, "partition", partition, "offset", offset)
+	return partition, offset, nil
+}
@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// ConnFlags registers command line flags of a connection to Kafka in fs, e.g., -tls or -acks.
+// The returned function makes the Client's options once the flags are parsed.
+// The commands set the flags with env vars as well, e.g., SASL_PASSWORD.
+func ConnFlags(fs *flag.FlagSet) func() ([]ConfigOption, error) {
+	brokers := fs.String("broker", "127.0.0.1:9092", "Comma-separated broker addresses to connect to.")
+	clientID := fs.String("client-id", "", "Client ID which brokers use in logs and quotas.")
+	version := fs.String("kafka-version", sarama.V0_11_0_0.String(), "Version of the brokers, at least 0.11.0.0.")
+	acks := fs.String("acks", "1", "Replica acknowledgements the producer waits for: 0, 1 (leader) or all.")
+	compression := fs.String("compression", "none", "Compression of produced messages: none, gzip, snappy, lz4 or zstd.")
+	retries := fs.Int("retries", 3, "How many times the producer retries to send a message.")
+	partitioner := fs.String("partitioner", "hash", "How message keys are assigned to partitions: hash, murmur2 or jump. All producers must use the same one.")
+	useTLS := fs.Bool("tls", false, "Connect to the brokers using TLS.")
+	tlsCA := fs.String("tls-ca", "", "PEM file with CA certificates to verify the brokers, system roots are used by default.")
+	tlsCert := fs.String("tls-cert", "", "PEM file with the client certificate.")
+	tlsKey := fs.String("tls-key", "", "PEM file with the client certificate's key.")
+	tlsInsecure := fs.Bool("tls-insecure", false, "Skip verification of the brokers' certificates.")
+	saslUser := fs.String("sasl-user", "", "SASL/PLAIN user name.")
+	saslPassword := fs.String("sasl-password", "", "SASL/PLAIN password.")
+	topicPartitions := fs.Int("topic-partitions", 0, "Expected number of partitions of the wallet topics (0 to skip the check).")
+	topicReplicas := fs.Int("topic-replicas", 1, "Replication factor of the wallet topics created with -create-topics.")
+	topicRetention := fs.Duration("topic-retention", 0, "Expected retention of the wallet topics which override the broker's default (0 to skip the check).")
+	createTopics := fs.Bool("create-topics", false, "Create missing wallet topics with -topic-partitions, -topic-replicas and -topic-retention.")
+
+	return func() ([]ConfigOption, error) {
+		opts := []ConfigOption{
+			WithBrokers(strings.Split(*brokers, ",")...),
+			WithRetries(*retries),
+		}
+		if *clientID != "" {
+			opts = append(opts, WithClientID(*clientID))
+		}
+
+		v, err := sarama.ParseKafkaVersion(*version)
+		if err != nil {
+			return nil, errors.Wrap(err, "kafka version")
+		}
+		opts = append(opts, WithKafkaVersion(v))
+
+		switch *acks {
+		case "0":
+			opts = append(opts, WithRequiredAcks(sarama.NoResponse))
+		case "1":
+			opts = append(opts, WithRequiredAcks(sarama.WaitForLocal))
+		case "all", "-1":
+			opts = append(opts, WithRequiredAcks(sarama.WaitForAll))
+		default:
+			return nil, errors.Errorf("unknown acks %q", *acks)
+		}
+
+		var codec sarama.CompressionCodec
+		if err = codec.UnmarshalText([]byte(*compression)); err != nil {
+			return nil, errors.Wrap(err, "compression")
+		}
+		opts = append(opts, WithCompression(codec))
+
+		partition, err := PartitionerByName(*partitioner)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithPartitioner(partition))
+
+		if *useTLS {
+			cfg := tls.Config{InsecureSkipVerify: *tlsInsecure}
+			if *tlsCA != "" {
+				pem, err := ioutil.ReadFile(*tlsCA)
+				if err != nil {
+					return nil, errors.Wrap(err, "tls ca")
+				}
+				cfg.RootCAs = x509.NewCertPool()
+				if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+					return nil, errors.Errorf("tls ca: no certificates found in %s", *tlsCA)
+				}
+			}
+			if *tlsCert != "" {
+				cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+				if err != nil {
+					return nil, errors.Wrap(err, "tls cert")
+				}
+				cfg.Certificates = []tls.Certificate{cert}
+			}
+			opts = append(opts, WithTLS(&cfg))
+		}
+
+		if *saslUser != "" {
+			opts = append(opts, WithSASL(*saslUser, *saslPassword))
+		}
+
+		if *createTopics && *topicPartitions <= 0 {
+			return nil, errors.New("-create-topics requires -topic-partitions")
+		}
+		opts = append(opts,
+			WithTopics(DefaultTopics(int32(*topicPartitions), int16(*topicReplicas), *topicRetention)...),
+			WithCreateTopics(*createTopics),
+		)
+		return opts, nil
+	}
+}
@@ -0,0 +1,116 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// ErrOffsetBeforeClaim is returned by FromOffset when the offset precedes the group's committed offset
+// of the claimed partition, since the claim can't be rewound, see Consume.
+var ErrOffsetBeforeClaim = errors.New("kafka: offset precedes the claimed partition's committed offset")
+
+// PartitionHandler processes a partition of a topic assigned to the consumer group member, see Consume.
+// It must return when ctx is done, i.e., the partition is revoked during rebalance or the member leaves the group.
+type PartitionHandler func(ctx context.Context, partition int32) error
+
+// Consume joins the consumer group and calls h in a separate goroutine for each assigned partition of the topic.
+// Partitions are reassigned automatically when group members come and go or partitions are added,
+// so partition-local state should be opened and closed by h.
+//
+// FromOffset of a service called with h's ctx reads the messages claimed by the group member
+// instead of creating a partition consumer. The claimed messages start from the group's committed offset,
+// the ones before the offset passed to FromOffset are skipped, and an earlier offset is rejected with ErrOffsetBeforeClaim.
+//
+// CommitOffset of a service called with h's ctx marks the offset in the group session,
+// so the partition's next claim starts after it.
+//
+// Consume joins the group set by WithGroup and blocks until ctx is cancelled or h returns an error.
+func (c *Client) Consume(ctx context.Context, topic string, h PartitionHandler) error {
+	group := c.copts.group
+	if group == "" {
+		return ErrNoGroup
+	}
+	g, err := sarama.NewConsumerGroupFromClient(group, c.conn)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "consumer group not created", "group", group, "err", err)
+		return err
+	}
+	defer g.Close()
+
+	handler := groupHandler{
+		client: c,
+		handle: h,
+		errc:   make(chan error, 1),
+	}
+	for {
+		c.logger.Log("level", "debug", "msg", "joining consumer group", "group", group, "topic", topic)
+		// Consume returns when a rebalance happens, so it's called in a loop to rejoin the group.
+		if err = g.Consume(ctx, []string{topic}, &handler); err != nil {
+			c.logger.Log("level", "debug", "msg", "consumer group failed", "group", group, "topic", topic, "err", err)
+			return err
+		}
+
+		select {
+		case err = <-handler.errc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+}
+
+// claimKey is a context key of the consumer group claim.
+type claimKey struct{}
+
+// groupClaim is a partition claimed by the group member within the session.
+type groupClaim struct {
+	session sarama.ConsumerGroupSession
+	claim   sarama.ConsumerGroupClaim
+}
+
+// claimFromContext returns the consumer group claim of the partition stored in ctx by Consume.
+func claimFromContext(ctx context.Context, topic string, partition int32) *groupClaim {
+	gc, ok := ctx.Value(claimKey{}).(*groupClaim)
+	if !ok || gc.claim.Topic() != topic || gc.claim.Partition() != partition {
+		return nil
+	}
+	return gc
+}
+
+// groupHandler calls the partition handler for each claim of a consumer group session.
+type groupHandler struct {
+	client *Client
+	handle PartitionHandler
+	// errc keeps the first error of the partition handler, so Consume could return it.
+	errc chan error
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim.
+func (h *groupHandler) Setup(s sarama.ConsumerGroupSession) error {
+	h.client.logger.Log("level", "debug", "msg", "partitions assigned", "claims", s.Claims(), "generation", s.GenerationID())
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+func (h *groupHandler) Cleanup(s sarama.ConsumerGroupSession) error {
+	h.client.logger.Log("level", "debug", "msg", "partitions revoked", "claims", s.Claims(), "generation", s.GenerationID())
+	return nil
+}
+
+// ConsumeClaim calls the partition handler with a context which carries the claim.
+// The context is cancelled when the session ends, e.g., during rebalance.
+func (h *groupHandler) ConsumeClaim(s sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := context.WithValue(s.Context(), claimKey{}, &groupClaim{session: s, claim: claim})
+	err := h.handle(ctx, claim.Partition())
+	if err != nil && err != context.Canceled {
+		select {
+		case h.errc <- err:
+		default:
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// fakeClaim is a partition claimed by a consumer group member whose committed offset is 5.
+type fakeClaim struct {
+	msgs chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                            { return DefaultPaymentTopic }
+func (c *fakeClaim) Partition() int32                         { return 1 }
+func (c *fakeClaim) InitialOffset() int64                     { return 5 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 10 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
+
+func TestClient_partitionMessages_claim(t *testing.T) {
+	tests := map[string]struct {
+		offset int64
+		from   int64
+		err    error
+	}{
+		"committed":    {wallet.OffsetCommitted, 5, nil},
+		"newest":       {wallet.OffsetNewest, 10, nil},
+		"after claim":  {7, 7, nil},
+		"claim offset": {5, 5, nil},
+		"before claim": {3, 0, ErrOffsetBeforeClaim},
+		"oldest":       {wallet.OffsetOldest, 0, ErrOffsetBeforeClaim},
+	}
+
+	c := NewClient(WithGroup("accountantd"))
+	ctx := context.WithValue(context.Background(), claimKey{}, &groupClaim{claim: &fakeClaim{}})
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, from, _, _, err := c.partitionMessages(ctx, DefaultPaymentTopic, 1, tc.offset)
+			if errors.Cause(err) != tc.err {
+				t.Fatalf("error: %v, want %v", err, tc.err)
+			}
+			if from != tc.from {
+				t.Errorf("from: %d, want %d", from, tc.from)
+			}
+		})
+	}
+}
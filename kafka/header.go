@@ -0,0 +1,61 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// Headers which describe where a transfer or a payment came from, see wallet.Metadata.
+const (
+	headerTraceID   = "trace_id"
+	headerProducer  = "producer"
+	headerCreatedAt = "created_at"
+)
+
+// headers returns headers of a transfer or a payment being produced: how it's encoded,
+// which service created it and when, and the trace ID from ctx if there is one.
+func (c *Client) headers(ctx context.Context) []sarama.RecordHeader {
+	headers := codecHeaders(c.copts.codec)
+	headers = append(headers, sarama.RecordHeader{
+		Key:   []byte(headerCreatedAt),
+		Value: []byte(time.Now().UTC().Format(time.RFC3339Nano)),
+	})
+	if c.copts.serviceName != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(headerProducer), Value: []byte(c.copts.serviceName)})
+	}
+	if traceID := wallet.TraceID(ctx); traceID != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(headerTraceID), Value: []byte(traceID)})
+	}
+	return headers
+}
+
+// messageMetadata returns metadata of the message based on its headers.
+// Messages without headers have the first schema version and Kafka's timestamp as a creation time.
+func messageMetadata(m *sarama.ConsumerMessage) wallet.Metadata {
+	meta := wallet.Metadata{
+		CreatedAt:     m.Timestamp,
+		SchemaVersion: SchemaV1,
+	}
+	for _, h := range m.Headers {
+		switch string(h.Key) {
+		case headerTraceID:
+			meta.TraceID = string(h.Value)
+		case headerProducer:
+			meta.Producer = string(h.Value)
+		case headerCreatedAt:
+			if t, err := time.Parse(time.RFC3339Nano, string(h.Value)); err == nil {
+				meta.CreatedAt = t
+			}
+		case headerSchemaVersion:
+			if v, err := strconv.Atoi(string(h.Value)); err == nil {
+				meta.SchemaVersion = v
+			}
+		}
+	}
+	return meta
+}
@@ -0,0 +1,216 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// MessageError describes a message which couldn't be processed, e.g., its JSON is malformed.
+type MessageError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("kafka: message %s/%d@%d: %v", e.Topic, e.Partition, e.Offset, e.Err)
+}
+
+// Cause returns the underlying error, so errors.Cause can unwrap MessageError.
+func (e *MessageError) Cause() error {
+	return e.Err
+}
+
+// ErrorHandler decides what to do with a message which couldn't be processed.
+// Returning nil skips the message, returning an error stops reading the partition
+// and the error is sent to the errc channel of FromOffset.
+type ErrorHandler func(*MessageError) error
+
+// StopOnError stops reading the partition at the first failed message. It is the default error handler.
+func StopOnError(err *MessageError) error {
+	return err
+}
+
+// SkipOnError skips failed messages. Use it with caution, since skipped messages are lost.
+func SkipOnError(err *MessageError) error {
+	return nil
+}
+
+// RouteErrors sends failed messages' errors to the errs channel and skips the messages,
+// so a caller could log or persist them elsewhere. Reading the partition is blocked until an error is received.
+func RouteErrors(errs chan<- *MessageError) ErrorHandler {
+	return func(err *MessageError) error {
+		errs <- err
+		return nil
+	}
+}
+
+// messages fetches Kafka messages from the given partition and calls f.
+// A message which f failed to process after max attempts is forwarded to the dead letter topic if it's set,
+// otherwise it's passed to the Client's error handler.
+// When ctx is cancelled, the ctx error is returned.
+func (c *Client) messages(ctx context.Context, topic string, partition int32, offset int64, f func(*sarama.ConsumerMessage) error) error {
+	c.logger.Log("level", "debug", "msg", "messages reading started", "topic", topic, "partition", partition, "offset", offset)
+
+	msgs, from, hwm, stop, err := c.partitionMessages(ctx, topic, partition, offset)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "messages consumer not created", "err", err, "topic", topic, "partition", partition, "offset", offset)
+		return err
+	}
+	defer stop()
+
+	for {
+		var m *sarama.ConsumerMessage
+		select {
+		case m = <-msgs:
+		case <-ctx.Done():
+		}
+		// Messages channel is closed or ctx is cancelled.
+		if m == nil {
+			break
+		}
+		// A claimed partition could start before the requested offset.
+		if m.Offset < from {
+			continue
+		}
+
+		c.logger.Log("level", "debug", "msg", "message received", "body", m.Value, "topic", topic, "partition", m.Partition, "offset", m.Offset)
+		for attempt := 1; ; attempt++ {
+			if err = f(m); err == nil || ctx.Err() != nil || attempt >= c.copts.maxAttempts {
+				break
+			}
+			c.logger.Log("level", "debug", "msg", "message attempt failed", "err", err, "attempt", attempt, "topic", topic, "partition", m.Partition, "offset", m.Offset)
+		}
+		if err == nil {
+			// The reader has got the message, it's reported to the metrics once the reader processed it.
+			c.delivered(topic, partition, m.Offset, hwm)
+			continue
+		}
+		// The message wasn't failed, the caller stopped reading.
+		if ctx.Err() != nil {
+			break
+		}
+
+		merr := MessageError{
+			Topic:     m.Topic,
+			Partition: m.Partition,
+			Offset:    m.Offset,
+			Err:       err,
+		}
+		c.logger.Log("level", "debug", "msg", "message failed", "err", err, "topic", topic, "partition", m.Partition, "offset", m.Offset)
+		if err = c.fail(&merr, func() (*sarama.ConsumerMessage, error) { return m, nil }); err != nil {
+			c.logger.Log("level", "debug", "msg", "messages reading stopped", "err", err, "topic", topic, "partition", partition)
+			return err
+		}
+	}
+
+	c.logger.Log("level", "debug", "msg", "messages reading stopped", "topic", topic, "partition", partition, "offset", offset)
+	return ctx.Err()
+}
+
+// partitionMessages returns a channel of messages of the partition, the offset to start from,
+// and a func which returns the partition's high water mark known to the consumer.
+// When ctx belongs to a consumer group claim of the partition (see Consume), the claimed messages are returned,
+// otherwise a partition consumer is created. Call stop to release the consumer.
+func (c *Client) partitionMessages(ctx context.Context, topic string, partition int32, offset int64) (msgs <-chan *sarama.ConsumerMessage, from int64, hwm func() int64, stop func(), err error) {
+	if gc := claimFromContext(ctx, topic, partition); gc != nil {
+		claim := gc.claim
+		switch offset {
+		case wallet.OffsetCommitted:
+			from = claim.InitialOffset()
+		case sarama.OffsetOldest:
+			from = 0
+		case sarama.OffsetNewest:
+			from = claim.HighWaterMarkOffset()
+		default:
+			from = offset
+		}
+		// Claim's messages start from the group's committed offset which can't be rewound.
+		if from < claim.InitialOffset() {
+			c.logger.Log("level", "debug", "msg", "claim starts after requested offset", "topic", topic, "partition", partition, "offset", offset, "claim_offset", claim.InitialOffset())
+			return nil, 0, nil, nil, errors.Wrapf(ErrOffsetBeforeClaim, "%s/%d offset %d, claim starts at %d", topic, partition, from, claim.InitialOffset())
+		}
+		return claim.Messages(), from, claim.HighWaterMarkOffset, func() {}, nil
+	}
+
+	if offset == wallet.OffsetCommitted {
+		if offset, err = c.committedOffset(topic, partition); err != nil {
+			return nil, 0, nil, nil, err
+		}
+	}
+	pConsumer, err := c.consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	stop = func() {
+		pConsumer.Close()
+		c.logger.Log("level", "debug", "msg", "messages consumer closed", "err", ctx.Err(), "topic", topic, "partition", partition, "offset", offset)
+	}
+	return pConsumer.Messages(), 0, pConsumer.HighWaterMarkOffset, stop, nil
+}
+
+// fail forwards the failed message to the dead letter topic if it's set, otherwise passes it to the error handler.
+// The message is fetched only when it's forwarded. Nil is returned when the message should be skipped.
+func (c *Client) fail(merr *MessageError, fetch func() (*sarama.ConsumerMessage, error)) error {
+	// Dead letters themselves are never forwarded to avoid a loop.
+	if c.copts.deadLetterTopic != "" && merr.Topic != c.copts.deadLetterTopic {
+		m, err := fetch()
+		if err == nil {
+			err = c.forward(m, merr.Err)
+		}
+		if err == nil {
+			return nil
+		}
+		// The message is handled as usual when it couldn't be forwarded, e.g., it's not lost with StopOnError.
+		merr.Err = errors.Wrapf(merr.Err, "dead letter not forwarded: %v", err)
+	}
+	return c.copts.errorHandler(merr)
+}
+
+// Fail handles a message which was read successfully, but the caller couldn't process it,
+// e.g., paymentd couldn't create payments of a transfer. The message is forwarded to the dead letter topic
+// or passed to the error handler the same way as a message which couldn't be decoded.
+// Nil is returned when the message should be skipped, otherwise the caller should stop reading the partition.
+func (c *Client) Fail(ctx context.Context, topic string, partition int32, offset int64, cause error) error {
+	merr := MessageError{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Err:       cause,
+	}
+	c.logger.Log("level", "debug", "msg", "message failed", "err", cause, "topic", topic, "partition", partition, "offset", offset)
+	return c.fail(&merr, func() (*sarama.ConsumerMessage, error) {
+		return c.fetch(ctx, topic, partition, offset)
+	})
+}
+
+// fetch reads the message at offset of the partition. A separate consumer is used,
+// because the Client's consumer could be reading the partition already.
+func (c *Client) fetch(ctx context.Context, topic string, partition int32, offset int64) (*sarama.ConsumerMessage, error) {
+	consumer, err := sarama.NewConsumerFromClient(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+	pConsumer, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer pConsumer.Close()
+
+	select {
+	case m := <-pConsumer.Messages():
+		if m.Offset != offset {
+			return nil, errors.Errorf("kafka: message %s/%d@%d not found", topic, partition, offset)
+		}
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestErrorHandlers(t *testing.T) {
+	merr := MessageError{Topic: DefaultPaymentTopic, Partition: 1, Offset: 7, Err: errors.New("malformed JSON")}
+
+	if err := StopOnError(&merr); err != &merr {
+		t.Errorf("StopOnError() = %v, want %v", err, &merr)
+	}
+	if err := SkipOnError(&merr); err != nil {
+		t.Errorf("SkipOnError() = %v, want nil", err)
+	}
+
+	errs := make(chan *MessageError, 1)
+	if err := RouteErrors(errs)(&merr); err != nil {
+		t.Errorf("RouteErrors() = %v, want nil", err)
+	}
+	if got := <-errs; got != &merr {
+		t.Errorf("routed error: %v, want %v", got, &merr)
+	}
+}
+
+// readFailed reads two messages of the payment topic's partition 0, the first one fails to be processed.
+// It returns an error of messages once the second message is read.
+func readFailed(t *testing.T, c *Client) error {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.ExpectConsumePartition(DefaultPaymentTopic, 0, sarama.OffsetOldest).
+		YieldMessage(&sarama.ConsumerMessage{
+			Key:     []byte("Alice"),
+			Value:   []byte(`{"account":"Alice"`),
+			Headers: []*sarama.RecordHeader{{Key: []byte(headerContentType), Value: []byte("application/json")}},
+		}).
+		YieldMessage(&sarama.ConsumerMessage{Key: []byte("Bob"), Value: []byte(`{"account":"Bob"}`)})
+	c.consumer = consumer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	return c.messages(ctx, DefaultPaymentTopic, 0, sarama.OffsetOldest, func(m *sarama.ConsumerMessage) error {
+		if m.Offset == 0 {
+			return errors.New("unexpected end of JSON input")
+		}
+		cancel()
+		return nil
+	})
+}
+
+func TestClient_messages_skip(t *testing.T) {
+	errs := make(chan *MessageError, 1)
+	c := NewClient(WithErrorHandler(RouteErrors(errs)))
+	if err := readFailed(t, c); err != context.Canceled {
+		t.Fatalf("messages() error: %v, want %v", err, context.Canceled)
+	}
+
+	merr := <-errs
+	if merr.Topic != DefaultPaymentTopic || merr.Partition != 0 || merr.Offset != 0 {
+		t.Errorf("routed message: %s/%d@%d, want %s/0@0", merr.Topic, merr.Partition, merr.Offset, DefaultPaymentTopic)
+	}
+}
+
+func TestClient_messages_stop(t *testing.T) {
+	c := NewClient(WithErrorHandler(StopOnError))
+	err := readFailed(t, c)
+	merr, ok := err.(*MessageError)
+	if !ok {
+		t.Fatalf("messages() error: %v, want MessageError", err)
+	}
+	if merr.Offset != 0 || merr.Err.Error() != "unexpected end of JSON input" {
+		t.Errorf("message error: %v", merr)
+	}
+}
+
+func TestClient_messages_deadLetter(t *testing.T) {
+	c := NewClient(
+		WithDeadLetterTopic(DefaultDeadLetterTopic),
+		WithErrorHandler(func(err *MessageError) error {
+			t.Errorf("forwarded message passed to error handler: %v", err)
+			return err
+		}),
+	)
+	var forwarded *sarama.ProducerMessage
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(m *sarama.ProducerMessage) error {
+		forwarded = m
+		return nil
+	})
+	c.producer = producer
+
+	if err := readFailed(t, c); err != context.Canceled {
+		t.Fatalf("messages() error: %v, want %v", err, context.Canceled)
+	}
+	if forwarded == nil {
+		t.Fatal("message not forwarded")
+	}
+	if forwarded.Topic != DefaultDeadLetterTopic {
+		t.Errorf("forwarded to %s, want %s", forwarded.Topic, DefaultDeadLetterTopic)
+	}
+
+	// The dead letter tells where the message came from, why it failed, and keeps the original headers.
+	key, _ := forwarded.Key.Encode()
+	value, _ := forwarded.Value.Encode()
+	m := sarama.ConsumerMessage{Key: key, Value: value}
+	for i := range forwarded.Headers {
+		m.Headers = append(m.Headers, &forwarded.Headers[i])
+	}
+	var l DeadLetter
+	if err := l.parseHeaders(m.Headers); err != nil {
+		t.Fatal(err)
+	}
+	if l.Topic != DefaultPaymentTopic || l.Partition != 0 || l.Offset != 0 {
+		t.Errorf("dead letter origin: %s/%d@%d, want %s/0@0", l.Topic, l.Partition, l.Offset, DefaultPaymentTopic)
+	}
+	if l.Err != "unexpected end of JSON input" {
+		t.Errorf("dead letter error: %q", l.Err)
+	}
+	if len(l.Headers) != 1 || string(l.Headers[0].Key) != headerContentType {
+		t.Errorf("dead letter headers: %v, want %s", l.Headers, headerContentType)
+	}
+	if string(key) != "Alice" || string(value) != `{"account":"Alice"` {
+		t.Errorf("dead letter: %s %s", key, value)
+	}
+}
+
+func TestClient_messages_deadLetterNotForwarded(t *testing.T) {
+	c := NewClient(
+		WithDeadLetterTopic(DefaultDeadLetterTopic),
+		WithErrorHandler(StopOnError),
+	)
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	c.producer = producer
+
+	// The message isn't lost when it couldn't be forwarded, the error handler stops reading.
+	err := readFailed(t, c)
+	merr, ok := err.(*MessageError)
+	if !ok {
+		t.Fatalf("messages() error: %v, want MessageError", err)
+	}
+	if !strings.Contains(merr.Err.Error(), "dead letter not forwarded") {
+		t.Errorf("message error: %v", merr)
+	}
+}
+
+func TestClient_Fail(t *testing.T) {
+	errs := make(chan *MessageError, 1)
+	c := NewClient(WithErrorHandler(RouteErrors(errs)))
+
+	cause := errors.New("create payments: broker unavailable")
+	if err := c.Fail(context.Background(), DefaultTransferTopic, 1, 42, cause); err != nil {
+		t.Fatalf("Fail() = %v, want nil", err)
+	}
+	merr := <-errs
+	if merr.Topic != DefaultTransferTopic || merr.Partition != 1 || merr.Offset != 42 || merr.Err != cause {
+		t.Errorf("routed error: %v", merr)
+	}
+
+	c = NewClient(WithErrorHandler(StopOnError))
+	if err := c.Fail(context.Background(), DefaultTransferTopic, 1, 42, cause); err == nil {
+		t.Fatal("Fail() = nil, want error")
+	}
+}
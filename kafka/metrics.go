@@ -0,0 +1,121 @@
+package kafka
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are instruments the Client reports consumption progress of partitions to, see WithMetrics.
+// They are labeled with topic and partition, so you can tell which partition falls behind.
+// A message is reported once the reader processed it, i.e., committed its offset or called Client.Processed.
+type Metrics struct {
+	// HighWaterMark is the offset of the message that will be appended to the partition next.
+	HighWaterMark metrics.Gauge
+	// Offset is the offset of the last processed message.
+	Offset metrics.Gauge
+	// Lag is the number of messages which are left to process.
+	Lag metrics.Gauge
+	// Messages counts processed messages, its rate is the number of messages per second.
+	Messages metrics.Counter
+	// Latency is how long a message was processed in seconds, i.e., since it was handed to the reader
+	// until its offset was committed, so retries of the reader are included.
+	Latency metrics.Histogram
+}
+
+// NewPrometheusMetrics returns Metrics registered in the default Prometheus registry,
+// e.g., wallet_consumer_lag{topic="wallet.payment",partition="1"}. Call it once per process.
+func NewPrometheusMetrics() *Metrics {
+	labels := []string{"topic", "partition"}
+	return &Metrics{
+		HighWaterMark: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "wallet",
+			Subsystem: "consumer",
+			Name:      "high_water_mark",
+			Help:      "Offset of the message that will be appended to the partition next.",
+		}, labels),
+		Offset: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "wallet",
+			Subsystem: "consumer",
+			Name:      "offset",
+			Help:      "Offset of the last processed message.",
+		}, labels),
+		Lag: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "wallet",
+			Subsystem: "consumer",
+			Name:      "lag",
+			Help:      "Number of messages which are left to process.",
+		}, labels),
+		Messages: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "wallet",
+			Subsystem: "consumer",
+			Name:      "messages_total",
+			Help:      "Number of processed messages.",
+		}, labels),
+		Latency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "wallet",
+			Subsystem: "consumer",
+			Name:      "latency_seconds",
+			Help:      "How long a message was processed since it was handed to the reader.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, labels),
+	}
+}
+
+// delivery is a message which was handed to a reader, but it isn't processed yet.
+type delivery struct {
+	offset int64
+	at     time.Time
+	// hwm returns the partition's high water mark known to the consumer.
+	hwm func() int64
+}
+
+// delivered remembers when the message at offset was handed to a reader, so its latency is known once it's processed.
+func (c *Client) delivered(topic string, partition int32, offset int64, hwm func() int64) {
+	if c.copts.metrics == nil {
+		return
+	}
+	tp := topicPartition{topic: topic, partition: partition}
+	c.mu.Lock()
+	c.deliveries[tp] = delivery{offset: offset, at: time.Now(), hwm: hwm}
+	c.mu.Unlock()
+}
+
+// Processed reports the message at offset of the partition as processed to the metrics, see WithMetrics.
+// Committed offsets are reported by the Client itself, so only readers which don't commit offsets should call it,
+// e.g., transfer-server which applies transfer events. Messages which weren't read by the Client are ignored.
+func (c *Client) Processed(topic string, partition int32, offset int64) {
+	if c.copts.metrics == nil {
+		return
+	}
+	tp := topicPartition{topic: topic, partition: partition}
+	c.mu.Lock()
+	d, ok := c.deliveries[tp]
+	if ok && d.offset == offset {
+		delete(c.deliveries, tp)
+	}
+	c.mu.Unlock()
+	if !ok || d.offset != offset {
+		return
+	}
+	c.observe(topic, partition, offset, d.hwm(), time.Since(d.at))
+}
+
+// observe reports the message at offset processed in d when the partition's high water mark was hwm.
+func (c *Client) observe(topic string, partition int32, offset, hwm int64, d time.Duration) {
+	mm := c.copts.metrics
+	labels := []string{"topic", topic, "partition", strconv.Itoa(int(partition))}
+	mm.HighWaterMark.With(labels...).Set(float64(hwm))
+	mm.Offset.With(labels...).Set(float64(offset))
+	// The high water mark is the offset following the newest message.
+	lag := hwm - offset - 1
+	if lag < 0 {
+		lag = 0
+	}
+	mm.Lag.With(labels...).Set(float64(lag))
+	mm.Messages.With(labels...).Add(1)
+	mm.Latency.With(labels...).Observe(d.Seconds())
+}
@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClient_Processed(t *testing.T) {
+	labels := []string{"topic", "partition"}
+	offsets := stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{Name: "offset"}, labels)
+	messages := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "messages_total"}, labels)
+	latency := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{Name: "latency_seconds"}, labels)
+	m := Metrics{
+		HighWaterMark: kitprometheus.NewGauge(stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{Name: "high_water_mark"}, labels)),
+		Offset:        kitprometheus.NewGauge(offsets),
+		Lag:           kitprometheus.NewGauge(stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{Name: "lag"}, labels)),
+		Messages:      kitprometheus.NewCounter(messages),
+		Latency:       kitprometheus.NewHistogram(latency),
+	}
+	processed := messages.WithLabelValues(DefaultPaymentTopic, "0")
+	c := NewClient(WithMetrics(&m))
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.ExpectConsumePartition(DefaultPaymentTopic, 0, sarama.OffsetOldest).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte(`{"account":"Alice"}`)}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte(`{"account":"Bob"}`)})
+	c.consumer = consumer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := c.messages(ctx, DefaultPaymentTopic, 0, sarama.OffsetOldest, func(m *sarama.ConsumerMessage) error {
+		if m.Offset == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("messages() error: %v, want %v", err, context.Canceled)
+	}
+	// Messages handed to the reader aren't processed yet.
+	if got := testutil.ToFloat64(processed); got != 0 {
+		t.Fatalf("delivered messages reported %v times, want 0", got)
+	}
+
+	// The first message was superseded by the second one which is still being processed.
+	c.Processed(DefaultPaymentTopic, 0, 0)
+	if got := testutil.ToFloat64(processed); got != 0 {
+		t.Fatalf("stale message reported %v times, want 0", got)
+	}
+
+	c.Processed(DefaultPaymentTopic, 0, 1)
+	if got := testutil.ToFloat64(processed); got != 1 {
+		t.Errorf("processed message reported %v times, want 1", got)
+	}
+	if got := testutil.ToFloat64(offsets.WithLabelValues(DefaultPaymentTopic, "0")); got != 1 {
+		t.Errorf("offset %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(latency); got != 1 {
+		t.Errorf("latency observed in %d series, want 1", got)
+	}
+
+	// The message is reported once.
+	c.Processed(DefaultPaymentTopic, 0, 1)
+	if got := testutil.ToFloat64(processed); got != 1 {
+		t.Errorf("processed message reported %v times, want 1", got)
+	}
+}
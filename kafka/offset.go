@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// ErrNoGroup is returned when offsets are committed or Consume is called without a consumer group, see WithGroup.
+var ErrNoGroup = errors.New("kafka: consumer group is not set")
+
+// topicPartition identifies a partition of a topic.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// commit marks the message at offset as processed, so the partition is read from the next offset
+// when it's requested with wallet.OffsetCommitted. When ctx belongs to a consumer group claim of the partition,
+// the offset is marked in the group session, otherwise it's committed by the Client's offset manager.
+// The offsets are flushed to Kafka periodically and when the Client is closed.
+// The message is reported to the metrics as processed, see WithMetrics.
+func (c *Client) commit(ctx context.Context, topic string, partition int32, offset int64) error {
+	// Kafka keeps the offset of the next message to read.
+	next := offset + 1
+	if gc := claimFromContext(ctx, topic, partition); gc != nil {
+		gc.session.MarkOffset(topic, partition, next, "")
+		c.logger.Log("level", "debug", "msg", "offset marked", "topic", topic, "partition", partition, "offset", next)
+		c.Processed(topic, partition, offset)
+		return nil
+	}
+
+	pom, err := c.partitionOffsets(topic, partition)
+	if err != nil {
+		return err
+	}
+	pom.MarkOffset(next, "")
+	c.logger.Log("level", "debug", "msg", "offset committed", "topic", topic, "partition", partition, "offset", next)
+	c.Processed(topic, partition, offset)
+	return nil
+}
+
+// committedOffset returns the offset following the last committed one.
+// sarama.OffsetOldest is returned when nothing was committed yet.
+func (c *Client) committedOffset(topic string, partition int32) (int64, error) {
+	pom, err := c.partitionOffsets(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	offset, _ := pom.NextOffset()
+	c.logger.Log("level", "debug", "msg", "committed offset found", "group", c.copts.group, "topic", topic, "partition", partition, "offset", offset)
+	return offset, nil
+}
+
+// CommittedOffset returns the group's offset following the last committed message of the partition,
+// or wallet.OffsetOldest when nothing was committed yet. When ctx belongs to a consumer group claim
+// of the partition, it's the offset the claim starts at, see Consume.
+func (c *Client) CommittedOffset(ctx context.Context, topic string, partition int32) (int64, error) {
+	if gc := claimFromContext(ctx, topic, partition); gc != nil {
+		return gc.claim.InitialOffset(), nil
+	}
+	return c.committedOffset(topic, partition)
+}
+
+// offsetForTime returns the offset of the first message of the partition whose timestamp is at or after t.
+// Kafka looks it up in the partition's time index. sarama.OffsetNewest is returned when there is no such message,
+// so only new messages are read. Producers set message timestamps, see sarama.ProducerMessage.
+func (c *Client) offsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	offset, err := c.conn.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "offset for time not found", "topic", topic, "partition", partition, "time", t, "err", err)
+		return 0, err
+	}
+	c.logger.Log("level", "debug", "msg", "offset for time found", "topic", topic, "partition", partition, "time", t, "offset", offset)
+	return offset, nil
+}
+
+// partitionOffsets returns the offset manager of the partition creating it on the first call.
+func (c *Client) partitionOffsets(topic string, partition int32) (sarama.PartitionOffsetManager, error) {
+	if c.offsets == nil {
+		return nil, ErrNoGroup
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tp := topicPartition{topic: topic, partition: partition}
+	if pom, ok := c.pOffsets[tp]; ok {
+		return pom, nil
+	}
+	pom, err := c.offsets.ManagePartition(topic, partition)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "partition offset manager not created", "group", c.copts.group, "topic", topic, "partition", partition, "err", err)
+		return nil, err
+	}
+	c.pOffsets[tp] = pom
+	return pom, nil
+}
@@ -0,0 +1,67 @@
+package kafka_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-payment/kafka"
+)
+
+func TestRejectionService_CommitOffset_noClaim(t *testing.T) {
+	const group = "paymentd-rejection"
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(kafka.DefaultRejectionTopic, 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, group, broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, kafka.DefaultRejectionTopic, 0, sarama.OffsetNewest, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	c := kafka.NewClient(
+		kafka.WithBrokers(broker.Addr()),
+		kafka.WithGroup(group),
+	)
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	// Rejections aren't claimed by the group, so the offset is committed by the Client's offset manager.
+	if err := c.Rejection.CommitOffset(context.Background(), 0, 41); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	var req *sarama.OffsetCommitRequest
+	for _, rr := range broker.History() {
+		if r, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			req = r
+		}
+	}
+	if req == nil {
+		t.Fatal("offset not committed")
+	}
+	// The group has no members, a member of a live group would be rejected with an unknown generation.
+	if req.ConsumerGroup != group || req.ConsumerGroupGeneration != sarama.GroupGenerationUndefined {
+		t.Errorf("committed by %s generation %d, want %s generation %d", req.ConsumerGroup, req.ConsumerGroupGeneration, group, sarama.GroupGenerationUndefined)
+	}
+	offset, _, err := req.Offset(kafka.DefaultRejectionTopic, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 42 {
+		t.Errorf("committed offset: %d, want 42", offset)
+	}
+}
+
+func TestRejectionService_CommitOffset_noGroup(t *testing.T) {
+	c := kafka.NewClient()
+	if err := c.Rejection.CommitOffset(context.Background(), 0, 41); err != kafka.ErrNoGroup {
+		t.Fatalf("error: %v, want %v", err, kafka.ErrNoGroup)
+	}
+}
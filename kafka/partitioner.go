@@ -0,0 +1,173 @@
+package kafka
+
+import (
+	"hash/fnv"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// PartitionFunc assigns a message key to one of numPartitions partitions, see WithPartitioner.
+// All producers of a topic must use the same function, otherwise messages with the same key
+// end up in different partitions, e.g., an account's payments are applied by different accountantd instances.
+type PartitionFunc func(key []byte, numPartitions int32) int32
+
+// HashPartition is sarama's default partitioner: FNV-1a hash of the key modulo the number of partitions.
+func HashPartition(key []byte, numPartitions int32) int32 {
+	h := fnv.New32a()
+	h.Write(key)
+	p := int32(h.Sum32()) % numPartitions
+	if p < 0 {
+		p = -p
+	}
+	return p
+}
+
+// Murmur2Partition is the Java client's default partitioner, so Go and Java producers
+// put messages with the same key into the same partition.
+func Murmur2Partition(key []byte, numPartitions int32) int32 {
+	return int32(murmur2(key)&0x7fffffff) % numPartitions
+}
+
+// JumpPartition assigns keys with jump consistent hash (Lamping and Veach).
+// When partitions are added, only 1/numPartitions of keys move and only to the new partitions,
+// whereas a modulo based partitioner reshuffles most of the keys.
+func JumpPartition(key []byte, numPartitions int32) int32 {
+	h := fnv.New64a()
+	h.Write(key)
+	return jumpHash(h.Sum64(), numPartitions)
+}
+
+// partitioners maps names of the built-in partitioners to their functions, see PartitionerByName.
+var partitioners = map[string]PartitionFunc{
+	"hash":    HashPartition,
+	"murmur2": Murmur2Partition,
+	"jump":    JumpPartition,
+}
+
+// PartitionerByName returns a partition function by its name: hash, murmur2 or jump.
+func PartitionerByName(name string) (PartitionFunc, error) {
+	f, ok := partitioners[name]
+	if !ok {
+		return nil, errors.Errorf("kafka: unknown partitioner %q", name)
+	}
+	return f, nil
+}
+
+// WithPartitioner sets how message keys are assigned to partitions (HashPartition by default).
+// Messages without a key are assigned to random partitions.
+func WithPartitioner(f PartitionFunc) ConfigOption {
+	return func(c *Client) {
+		c.config.Producer.Partitioner = func(topic string) sarama.Partitioner {
+			return &keyPartitioner{
+				partition: f,
+				random:    sarama.NewRandomPartitioner(topic),
+			}
+		}
+	}
+}
+
+// keyPartitioner adapts PartitionFunc to sarama.Partitioner.
+type keyPartitioner struct {
+	partition PartitionFunc
+	random    sarama.Partitioner
+}
+
+// Partition returns a partition of the message's key.
+func (p *keyPartitioner) Partition(m *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if m.Key == nil {
+		return p.random.Partition(m, numPartitions)
+	}
+	key, err := m.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return p.partition(key, numPartitions), nil
+}
+
+// RequiresConsistency tells sarama that a key must always go to the same partition,
+// so a message isn't sent elsewhere when its partition's leader is unavailable.
+func (p *keyPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// pinned marks a producer message which is sent to the partition set in the message, see Reinject.
+type pinned struct{}
+
+// pinnedPartitioner sends the pinned messages to their partitions
+// and assigns the rest of the messages with the Client's partitioner.
+type pinnedPartitioner struct {
+	next sarama.Partitioner
+}
+
+// withPinnedPartitions wraps the partitioner, so pinned messages keep their partitions.
+func withPinnedPartitions(next sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &pinnedPartitioner{next: next(topic)}
+	}
+}
+
+// Partition returns the message's partition if it's pinned, otherwise the partition of its key.
+func (p *pinnedPartitioner) Partition(m *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if _, ok := m.Metadata.(pinned); !ok {
+		return p.next.Partition(m, numPartitions)
+	}
+	if m.Partition < 0 || m.Partition >= numPartitions {
+		return -1, sarama.ErrInvalidPartition
+	}
+	return m.Partition, nil
+}
+
+// RequiresConsistency tells sarama that a pinned message must go to its partition
+// even when the partition's leader is unavailable.
+func (p *pinnedPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// murmur2 is the 32-bit MurmurHash2 as implemented by the Java client (org.apache.kafka.common.utils.Utils).
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// jumpHash maps the key to one of n buckets, see https://arxiv.org/abs/1406.2294.
+func jumpHash(key uint64, n int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
@@ -0,0 +1,88 @@
+package kafka_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/marselester/distributed-payment/kafka"
+)
+
+func TestHashPartition(t *testing.T) {
+	// Switching to WithPartitioner(kafka.HashPartition) doesn't move keys produced with sarama's default partitioner.
+	p := sarama.NewHashPartitioner("wallet.payment")
+	for _, account := range []string{"Alice", "Bob", "Bank", ""} {
+		m := sarama.ProducerMessage{Key: sarama.StringEncoder(account)}
+		want, err := p.Partition(&m, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := kafka.HashPartition([]byte(account), 7); got != want {
+			t.Errorf("HashPartition(%q) = %d, want %d", account, got, want)
+		}
+	}
+}
+
+func TestMurmur2Partition(t *testing.T) {
+	// The murmur2 hashes are taken from the Java client's tests, so the partitions match the Java producer's ones.
+	hashes := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, h := range hashes {
+		want := (h & 0x7fffffff) % 1000
+		if got := kafka.Murmur2Partition([]byte(key), 1000); got != want {
+			t.Errorf("Murmur2Partition(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestJumpPartition(t *testing.T) {
+	// Keys move only to the added partition when the partition count grows.
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("account-%d", i))
+		before := kafka.JumpPartition(key, 4)
+		after := kafka.JumpPartition(key, 5)
+		if before != after && after != 4 {
+			t.Fatalf("key %s moved from %d to %d, want to 4", key, before, after)
+		}
+	}
+}
+
+func TestClient_Reinject(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(kafka.DefaultPaymentTopic, 0, broker.BrokerID()).
+			SetLeader(kafka.DefaultPaymentTopic, 1, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	c := kafka.NewClient(
+		kafka.WithBrokers(broker.Addr()),
+		kafka.WithPartitioner(kafka.JumpPartition),
+	)
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The message was forwarded before the partitioner was changed, so the key is assigned to another partition now.
+	l := kafka.DeadLetter{Topic: kafka.DefaultPaymentTopic, Key: []byte("Alice"), Value: []byte(`{"account":"Alice"}`)}
+	l.Partition = 1 - kafka.JumpPartition(l.Key, 2)
+	partition, _, err := c.Reinject(context.Background(), &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partition != l.Partition {
+		t.Errorf("reinjected to partition %d, want %d", partition, l.Partition)
+	}
+}
@@ -2,7 +2,7 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"time"
 
 	"github.com/Shopify/sarama"
 
@@ -14,9 +14,9 @@ type PaymentService struct {
 	client *Client
 }
 
-// CreatePayment persists a payment encoded as JSON message.
+// CreatePayment persists a payment encoded by the Client's codec.
 func (s *PaymentService) CreatePayment(ctx context.Context, p *wallet.Payment) error {
-	b, err := json.Marshal(p)
+	b, err := s.client.copts.codec.EncodePayment(p)
 	if err != nil {
 		return err
 	}
@@ -25,8 +25,9 @@ func (s *PaymentService) CreatePayment(ctx context.Context, p *wallet.Payment) e
 	m := sarama.ProducerMessage{
 		Topic: s.client.copts.paymentTopic,
 		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
-		Key:   sarama.StringEncoder(p.Account),
-		Value: sarama.ByteEncoder(b),
+		Key:     sarama.StringEncoder(p.Account),
+		Value:   sarama.ByteEncoder(b),
+		Headers: s.client.headers(ctx),
 	}
 	partition, offset, err := s.client.producer.SendMessage(&m)
 	if err != nil {
@@ -49,9 +50,9 @@ func (s *PaymentService) FromOffset(ctx context.Context, partition int32, offset
 		// Close the payments channel after messages returns.
 		defer close(payments)
 
-		err := s.messages(ctx, s.client.copts.paymentTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
+		err := s.client.messages(ctx, s.client.copts.paymentTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
 			p := wallet.Payment{}
-			if err := json.Unmarshal(m.Value, &p); err != nil {
+			if err := decodePayment(m, &p); err != nil {
 				return err
 			}
 			p.Partition = m.Partition
@@ -72,27 +73,20 @@ func (s *PaymentService) FromOffset(ctx context.Context, partition int32, offset
 	return payments, errc
 }
 
-// messages fetches Kafka messages from the given partition and calls f.
-func (s *PaymentService) messages(ctx context.Context, topic string, partition int32, offset int64, f func(*sarama.ConsumerMessage) error) error {
-	s.client.logger.Log("level", "debug", "msg", "messages reading started", "topic", topic, "partition", partition, "offset", offset)
-
-	pConsumer, err := s.client.consumer.ConsumePartition(topic, partition, offset)
+// FromTime returns a channel of payments from the given partition starting at the first payment created at or after since.
+func (s *PaymentService) FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Payment, <-chan error) {
+	offset, err := s.client.offsetForTime(s.client.copts.paymentTopic, partition, since)
 	if err != nil {
-		s.client.logger.Log("level", "debug", "msg", "messages consumer not created", "err", err, "topic", topic, "partition", partition, "offset", offset)
-		return err
-	}
-	// Terminate message consuming by closing Messages channel when ctx is cancelled.
-	go func() {
-		<-ctx.Done()
-		pConsumer.Close()
-		s.client.logger.Log("level", "debug", "msg", "messages consumer closed", "err", ctx.Err(), "topic", topic, "partition", partition, "offset", offset)
-	}()
-
-	for m := range pConsumer.Messages() {
-		s.client.logger.Log("level", "debug", "msg", "message received", "body", m.Value, "topic", topic, "partition", partition, "offset", offset)
-		f(m)
+		payments := make(chan *wallet.Payment)
+		close(payments)
+		errc := make(chan error, 1)
+		errc <- err
+		return payments, errc
 	}
+	return s.FromOffset(ctx, partition, offset)
+}
 
-	s.client.logger.Log("level", "debug", "msg", "messages reading stopped", "topic", topic, "partition", partition, "offset", offset)
-	return nil
+// CommitOffset marks the payment at offset as processed, it requires the Client's consumer group.
+func (s *PaymentService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.commit(ctx, s.client.copts.paymentTopic, partition, offset)
 }
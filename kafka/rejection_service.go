@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// RejectionService represents a Kafka service to store payment rejections.
+type RejectionService struct {
+	client *Client
+}
+
+// CreateRejection persists a payment rejection encoded as JSON message.
+func (s *RejectionService) CreateRejection(ctx context.Context, r *wallet.Rejection) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.client.logger.Log("level", "debug", "msg", "creating rejection", "body", b)
+
+	m := sarama.ProducerMessage{
+		Topic: s.client.copts.rejectionTopic,
+		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
+		// Rejections are partitioned by request ID as transfer requests are, so paymentd finds
+		// a rejection in the partition with the same number as the transfer's partition.
+		Key:   sarama.StringEncoder(r.Payment.RequestID),
+		Value: sarama.ByteEncoder(b),
+	}
+	partition, offset, err := s.client.producer.SendMessage(&m)
+	if err != nil {
+		s.client.logger.Log("level", "debug", "msg", "rejection not created", "topic", s.client.copts.rejectionTopic, "body", b, "err", err)
+		return err
+	}
+	r.Partition = partition
+	r.SequenceID = offset
+
+	s.client.logger.Log("level", "debug", "msg", "rejection created", "partition", partition, "offset", offset, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of rejections from the given partition starting at offset.
+func (s *RejectionService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Rejection, <-chan error) {
+	rejections := make(chan *wallet.Rejection)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the rejections channel after messages returns.
+		defer close(rejections)
+
+		err := s.client.messages(ctx, s.client.copts.rejectionTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
+			r := wallet.Rejection{}
+			if err := json.Unmarshal(m.Value, &r); err != nil {
+				return err
+			}
+			r.Partition = m.Partition
+			r.SequenceID = m.Offset
+
+			select {
+			case rejections <- &r:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return rejections, errc
+}
+
+// CommitOffset marks the rejection at offset as processed, it requires the Client's consumer group.
+func (s *RejectionService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.commit(ctx, s.client.copts.rejectionTopic, partition, offset)
+}
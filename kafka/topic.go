@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// Errors returned by Open when the topics don't match their descriptions, see WithTopics.
+var (
+	ErrTopicNotFound      = errors.New("kafka: topic not found")
+	ErrTopicMisconfigured = errors.New("kafka: topic misconfigured")
+)
+
+// Topic describes a topic the Client expects to find in the cluster, see WithTopics.
+type Topic struct {
+	Name string
+	// Partitions is the expected number of partitions, zero means any number.
+	Partitions int32
+	// Replicas is a replication factor of the topic when it's created.
+	Replicas int16
+	// Compacted topics keep at least the latest message of each key instead of deleting old messages,
+	// e.g., DefaultBalanceTopic.
+	Compacted bool
+	// Retention is how long messages are kept, zero means it's not checked.
+	// Only topics which override the broker's default retention are checked.
+	Retention time.Duration
+}
+
+// DefaultTopics returns descriptions of the topics the wallet services use including the dead letter topic.
+// All of them have the same number of partitions, because a transfer's rejections are expected in the partition
+// with the same number as the transfer, and an account's balance changes in the partition of its payments.
+func DefaultTopics(partitions int32, replicas int16, retention time.Duration) []Topic {
+	return []Topic{
+		{Name: DefaultTransferTopic, Partitions: partitions, Replicas: replicas, Retention: retention},
+		{Name: DefaultPaymentTopic, Partitions: partitions, Replicas: replicas, Retention: retention},
+		{Name: DefaultRejectionTopic, Partitions: partitions, Replicas: replicas, Retention: retention},
+		{Name: DefaultTransferEventTopic, Partitions: partitions, Replicas: replicas, Retention: retention},
+		{Name: DefaultBalanceTopic, Partitions: partitions, Replicas: replicas, Compacted: true},
+		{Name: DefaultDeadLetterTopic, Partitions: partitions, Replicas: replicas, Retention: retention},
+	}
+}
+
+// Topic configs which are checked and set when a topic is created.
+const (
+	configCleanupPolicy = "cleanup.policy"
+	configRetention     = "retention.ms"
+)
+
+// checkTopics verifies that the Client's topics exist with the expected number of partitions,
+// cleanup policy and retention. Missing topics are created if it's enabled, see WithCreateTopics.
+func (c *Client) checkTopics() error {
+	if len(c.copts.topics) == 0 {
+		return nil
+	}
+
+	admin, err := sarama.NewClusterAdmin(c.copts.brokers, c.config)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "cluster admin not created", "err", err)
+		return err
+	}
+	defer admin.Close()
+
+	existing, err := admin.ListTopics()
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "topics not listed", "err", err)
+		return err
+	}
+
+	var created []string
+	for _, t := range c.copts.topics {
+		d, ok := existing[t.Name]
+		if !ok {
+			if !c.copts.createTopics {
+				return errors.Wrap(ErrTopicNotFound, t.Name)
+			}
+			if err = createTopic(admin, &t); err != nil {
+				c.logger.Log("level", "debug", "msg", "topic not created", "topic", t.Name, "err", err)
+				return errors.Wrapf(err, "kafka: create topic %s", t.Name)
+			}
+			c.logger.Log("level", "debug", "msg", "topic created", "topic", t.Name, "partitions", t.Partitions, "replicas", t.Replicas)
+			created = append(created, t.Name)
+			continue
+		}
+
+		if err = checkTopic(&t, &d); err != nil {
+			return err
+		}
+		c.logger.Log("level", "debug", "msg", "topic checked", "topic", t.Name, "partitions", d.NumPartitions)
+	}
+
+	if len(created) > 0 {
+		return c.conn.RefreshMetadata(created...)
+	}
+	return nil
+}
+
+// createTopic creates the topic with its partitions, replicas, cleanup policy and retention.
+func createTopic(admin sarama.ClusterAdmin, t *Topic) error {
+	if t.Partitions <= 0 {
+		return errors.New("number of partitions is not set")
+	}
+	replicas := t.Replicas
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	policy := "delete"
+	if t.Compacted {
+		policy = "compact"
+	}
+	d := sarama.TopicDetail{
+		NumPartitions:     t.Partitions,
+		ReplicationFactor: replicas,
+		ConfigEntries:     map[string]*string{configCleanupPolicy: &policy},
+	}
+	if t.Retention > 0 {
+		ms := strconv.FormatInt(int64(t.Retention/time.Millisecond), 10)
+		d.ConfigEntries[configRetention] = &ms
+	}
+	return admin.CreateTopic(t.Name, &d, false)
+}
+
+// checkTopic compares the topic's description with its details found in the cluster.
+// The configs which are left to the broker's defaults are absent in the details,
+// the default cleanup policy is delete.
+func checkTopic(t *Topic, d *sarama.TopicDetail) error {
+	if t.Partitions > 0 && d.NumPartitions != t.Partitions {
+		return errors.Wrapf(ErrTopicMisconfigured, "%s has %d partitions, want %d", t.Name, d.NumPartitions, t.Partitions)
+	}
+
+	compacted := false
+	if policy := d.ConfigEntries[configCleanupPolicy]; policy != nil {
+		compacted = strings.Contains(*policy, "compact")
+	}
+	switch {
+	case t.Compacted && !compacted:
+		return errors.Wrapf(ErrTopicMisconfigured, "%s is not compacted", t.Name)
+	case !t.Compacted && compacted:
+		// Compaction would remove messages which aren't the latest of their keys, e.g., Alice's older payments.
+		return errors.Wrapf(ErrTopicMisconfigured, "%s is compacted", t.Name)
+	}
+
+	retention := d.ConfigEntries[configRetention]
+	if t.Retention <= 0 || retention == nil {
+		return nil
+	}
+	ms, err := strconv.ParseInt(*retention, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "kafka: %s retention", t.Name)
+	}
+	if got := time.Duration(ms) * time.Millisecond; got != t.Retention {
+		return errors.Wrapf(ErrTopicMisconfigured, "%s retention is %s, want %s", t.Name, got, t.Retention)
+	}
+	return nil
+}
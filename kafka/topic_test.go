@@ -0,0 +1,119 @@
+package kafka_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	"github.com/marselester/distributed-payment/kafka"
+)
+
+// newTopicBroker returns a broker which has the payment topic with two partitions and 5s retention.
+func newTopicBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(kafka.DefaultPaymentTopic, 0, broker.BrokerID()).
+			SetLeader(kafka.DefaultPaymentTopic, 1, broker.BrokerID()),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"CreateTopicsRequest":    sarama.NewMockCreateTopicsResponse(t),
+	})
+	return broker
+}
+
+func TestClient_Open_topics(t *testing.T) {
+	tt := map[string]struct {
+		topic kafka.Topic
+		want  error
+	}{
+		"ok":                  {kafka.Topic{Name: kafka.DefaultPaymentTopic, Partitions: 2, Retention: 5 * time.Second}, nil},
+		"any partitions":      {kafka.Topic{Name: kafka.DefaultPaymentTopic}, nil},
+		"partitions mismatch": {kafka.Topic{Name: kafka.DefaultPaymentTopic, Partitions: 3}, kafka.ErrTopicMisconfigured},
+		"retention mismatch":  {kafka.Topic{Name: kafka.DefaultPaymentTopic, Retention: 168 * time.Hour}, kafka.ErrTopicMisconfigured},
+		"not compacted":       {kafka.Topic{Name: kafka.DefaultPaymentTopic, Compacted: true}, kafka.ErrTopicMisconfigured},
+		"not found":           {kafka.Topic{Name: kafka.DefaultBalanceTopic}, kafka.ErrTopicNotFound},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			broker := newTopicBroker(t)
+			c := kafka.NewClient(
+				kafka.WithBrokers(broker.Addr()),
+				kafka.WithTopics(tc.topic),
+			)
+			err := c.Open()
+			if err == nil {
+				c.Close()
+			}
+			if errors.Cause(err) != tc.want {
+				t.Errorf("Open() error: %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestClient_Open_createTopics(t *testing.T) {
+	broker := newTopicBroker(t)
+	c := kafka.NewClient(
+		kafka.WithBrokers(broker.Addr()),
+		kafka.WithTopics(
+			kafka.Topic{Name: kafka.DefaultPaymentTopic, Partitions: 2},
+			kafka.Topic{Name: kafka.DefaultBalanceTopic, Partitions: 2, Replicas: 3, Compacted: true},
+			kafka.Topic{Name: kafka.DefaultDeadLetterTopic, Partitions: 2, Retention: 168 * time.Hour},
+		),
+		kafka.WithCreateTopics(true),
+	)
+	// The payment topic exists, so only the balance and dead letter topics are created.
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	created := make(map[string]*sarama.TopicDetail)
+	for _, rr := range broker.History() {
+		if r, ok := rr.Request.(*sarama.CreateTopicsRequest); ok {
+			for name, d := range r.TopicDetails {
+				created[name] = d
+			}
+		}
+	}
+	if len(created) != 2 {
+		t.Fatalf("created topics: %v, want %s and %s", created, kafka.DefaultBalanceTopic, kafka.DefaultDeadLetterTopic)
+	}
+
+	balance := created[kafka.DefaultBalanceTopic]
+	if balance == nil || balance.NumPartitions != 2 || balance.ReplicationFactor != 3 || *balance.ConfigEntries["cleanup.policy"] != "compact" {
+		t.Errorf("%s: %+v, want 2 partitions, 3 replicas, compacted", kafka.DefaultBalanceTopic, balance)
+	}
+	deadLetter := created[kafka.DefaultDeadLetterTopic]
+	if deadLetter == nil || deadLetter.ReplicationFactor != 1 || *deadLetter.ConfigEntries["cleanup.policy"] != "delete" || *deadLetter.ConfigEntries["retention.ms"] != "604800000" {
+		t.Errorf("%s: %+v, want 1 replica, 168h retention", kafka.DefaultDeadLetterTopic, deadLetter)
+	}
+}
+
+func TestDefaultTopics(t *testing.T) {
+	topics := make(map[string]kafka.Topic)
+	for _, topic := range kafka.DefaultTopics(2, 1, 0) {
+		topics[topic.Name] = topic
+	}
+	for _, name := range []string{
+		kafka.DefaultTransferTopic,
+		kafka.DefaultPaymentTopic,
+		kafka.DefaultRejectionTopic,
+		kafka.DefaultTransferEventTopic,
+		kafka.DefaultBalanceTopic,
+		kafka.DefaultDeadLetterTopic,
+	} {
+		if topic, ok := topics[name]; !ok || topic.Partitions != 2 {
+			t.Errorf("%s: %+v, want 2 partitions", name, topic)
+		}
+	}
+	if !topics[kafka.DefaultBalanceTopic].Compacted {
+		t.Errorf("%s is not compacted", kafka.DefaultBalanceTopic)
+	}
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// ErrNoTransactions is returned when a transactional API is used without a transactional ID, see WithTransactionalID.
+var ErrNoTransactions = errors.New("kafka: transactional ID is not set")
+
+// CreateTransferPayments writes the payments created from the transfer request and commits the offset of the request
+// in one Kafka transaction, so either all of them are persisted or none. For example, a crash can't leave
+// Alice's outgoing payment without Bob's incoming payment. The offset is committed on behalf of
+// the Client's consumer group, so the transfer request isn't read again with wallet.OffsetCommitted.
+//
+// Readers see the payments only after the transaction is committed, since the Client reads committed messages.
+// It requires a transactional ID and a consumer group, see WithTransactionalID and WithGroup.
+func (s *PaymentService) CreateTransferPayments(ctx context.Context, t *wallet.Transfer, payments ...*wallet.Payment) error {
+	c := s.client
+	if c.txProducer == nil {
+		return ErrNoTransactions
+	}
+	if c.copts.group == "" {
+		return ErrNoGroup
+	}
+
+	msgs := make([]*sarama.ProducerMessage, len(payments))
+	for i, p := range payments {
+		b, err := c.copts.codec.EncodePayment(p)
+		if err != nil {
+			return err
+		}
+		msgs[i] = &sarama.ProducerMessage{
+			Topic: c.copts.paymentTopic,
+			// Sarama uses the message's key to consistently assign a partition to a message using hashing.
+			Key:     sarama.StringEncoder(p.Account),
+			Value:   sarama.ByteEncoder(b),
+			Headers: c.headers(ctx),
+		}
+	}
+
+	// The producer runs one transaction at a time, e.g., when partitions are processed concurrently by Consume.
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+
+	if err := c.txProducer.BeginTxn(); err != nil {
+		c.logger.Log("level", "debug", "msg", "transaction not started", "request", t.ID, "err", err)
+		return errors.Wrap(err, "begin transaction")
+	}
+	for i, m := range msgs {
+		partition, offset, err := c.txProducer.SendMessage(m)
+		if err != nil {
+			c.logger.Log("level", "debug", "msg", "payment not created", "topic", m.Topic, "request", t.ID, "err", err)
+			return c.abort(errors.Wrap(err, "send payment"))
+		}
+		payments[i].Partition = partition
+		payments[i].SequenceID = offset
+	}
+	offsets := map[string][]*sarama.PartitionOffsetMetadata{
+		c.copts.transferTopic: {
+			// Kafka keeps the offset of the next message to read.
+			{Partition: t.Partition, Offset: t.SequenceID + 1},
+		},
+	}
+	if err := c.txProducer.AddOffsetsToTxn(offsets, c.copts.group); err != nil {
+		c.logger.Log("level", "debug", "msg", "transfer offset not added to transaction", "request", t.ID, "partition", t.Partition, "offset", t.SequenceID, "err", err)
+		return c.abort(errors.Wrap(err, "add transfer offset"))
+	}
+	if err := c.txProducer.CommitTxn(); err != nil {
+		c.logger.Log("level", "debug", "msg", "transaction not committed", "request", t.ID, "err", err)
+		return c.abort(errors.Wrap(err, "commit transaction"))
+	}
+
+	c.logger.Log("level", "debug", "msg", "transfer payments created", "request", t.ID, "partition", t.Partition, "offset", t.SequenceID, "payments", len(payments))
+	c.Processed(c.copts.transferTopic, t.Partition, t.SequenceID)
+	return nil
+}
+
+// abort aborts the ongoing transaction and returns the error which caused it.
+func (c *Client) abort(cause error) error {
+	if err := c.txProducer.AbortTxn(); err != nil {
+		c.logger.Log("level", "debug", "msg", "transaction not aborted", "err", err)
+		return errors.Wrapf(cause, "transaction not aborted: %v", err)
+	}
+	c.logger.Log("level", "debug", "msg", "transaction aborted", "err", cause)
+	return cause
+}
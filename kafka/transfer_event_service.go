@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// TransferEventService represents a Kafka service to store transfer status changes.
+type TransferEventService struct {
+	client *Client
+}
+
+// CreateEvent persists a transfer event encoded as JSON message.
+func (s *TransferEventService) CreateEvent(ctx context.Context, e *wallet.TransferEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.client.logger.Log("level", "debug", "msg", "creating transfer event", "body", b)
+
+	m := sarama.ProducerMessage{
+		Topic: s.client.copts.eventTopic,
+		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
+		Key:   sarama.StringEncoder(e.RequestID),
+		Value: sarama.ByteEncoder(b),
+	}
+	partition, offset, err := s.client.producer.SendMessage(&m)
+	if err != nil {
+		s.client.logger.Log("level", "debug", "msg", "transfer event not created", "topic", s.client.copts.eventTopic, "body", b, "err", err)
+		return err
+	}
+	e.Partition = partition
+	e.SequenceID = offset
+
+	s.client.logger.Log("level", "debug", "msg", "transfer event created", "partition", partition, "offset", offset, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of transfer events from the given partition starting at offset.
+func (s *TransferEventService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.TransferEvent, <-chan error) {
+	events := make(chan *wallet.TransferEvent)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the events channel after messages returns.
+		defer close(events)
+
+		err := s.client.messages(ctx, s.client.copts.eventTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
+			e := wallet.TransferEvent{}
+			if err := json.Unmarshal(m.Value, &e); err != nil {
+				return err
+			}
+			e.Partition = m.Partition
+			e.SequenceID = m.Offset
+
+			select {
+			case events <- &e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return events, errc
+}
@@ -2,7 +2,8 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 
@@ -12,11 +13,58 @@ import (
 // TransferService represents a Kafka service to store money transfer requests.
 type TransferService struct {
 	client *Client
+	// mu serializes duplicate check and message sending, so concurrent retries can't both pass the check.
+	mu sync.Mutex
 }
 
-// CreateTransfer persists money transfer request encoded as JSON message.
+// CreateTransfer persists money transfer request encoded by the Client's codec.
+// When the Client has a transfer status service, a duplicate request ID is detected:
+// wallet.ErrTransferExists is returned and t is set to the original transfer if the request is the same,
+// otherwise wallet.ErrTransferConflict is returned.
+// The check is serialized within the process only, so a single transfer-server instance must handle the requests.
 func (s *TransferService) CreateTransfer(ctx context.Context, t *wallet.Transfer) error {
-	b, err := json.Marshal(t)
+	if s.client.status == nil {
+		return s.createTransfer(ctx, t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orig, err := s.client.status.Transfer(t.ID)
+	switch err {
+	case nil:
+		if !orig.SameRequest(t) {
+			s.client.logger.Log("level", "debug", "msg", "transfer conflict", "request", t.ID)
+			return wallet.ErrTransferConflict
+		}
+		s.client.logger.Log("level", "debug", "msg", "transfer exists", "request", t.ID)
+		*t = *orig
+		return wallet.ErrTransferExists
+	case wallet.ErrTransferNotFound:
+	default:
+		return err
+	}
+
+	if err = s.createTransfer(ctx, t); err != nil {
+		return err
+	}
+	// The transfer is recorded right away, because paymentd reports it as accepted asynchronously.
+	// It's been sent already, so an error isn't returned, otherwise a retry would send it again.
+	e := wallet.TransferEvent{
+		RequestID: t.ID,
+		Status:    wallet.StatusAccepted,
+		Time:      time.Now().UTC(),
+		Transfer:  t,
+	}
+	if err = s.client.status.ApplyEvent(&e); err != nil {
+		s.client.logger.Log("level", "info", "msg", "transfer status not saved", "request", t.ID, "err", err)
+	}
+	return nil
+}
+
+// createTransfer sends the transfer to Kafka.
+func (s *TransferService) createTransfer(ctx context.Context, t *wallet.Transfer) error {
+	b, err := s.client.copts.codec.EncodeTransfer(t)
 	if err != nil {
 		return err
 	}
@@ -25,8 +73,9 @@ func (s *TransferService) CreateTransfer(ctx context.Context, t *wallet.Transfer
 	m := sarama.ProducerMessage{
 		Topic: s.client.copts.transferTopic,
 		// Sarama uses the message's key to consistently assign a partition to a message using hashing.
-		Key:   sarama.StringEncoder(t.ID),
-		Value: sarama.ByteEncoder(b),
+		Key:     sarama.StringEncoder(t.ID),
+		Value:   sarama.ByteEncoder(b),
+		Headers: s.client.headers(ctx),
 	}
 	partition, offset, err := s.client.producer.SendMessage(&m)
 	if err != nil {
@@ -49,9 +98,9 @@ func (s *TransferService) FromOffset(ctx context.Context, partition int32, offse
 		// Close the transfers channel after messages returns.
 		defer close(transfers)
 
-		err := s.messages(ctx, s.client.copts.transferTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
+		err := s.client.messages(ctx, s.client.copts.transferTopic, partition, offset, func(m *sarama.ConsumerMessage) error {
 			t := wallet.Transfer{}
-			if err := json.Unmarshal(m.Value, &t); err != nil {
+			if err := decodeTransfer(m, &t); err != nil {
 				return err
 			}
 			t.Partition = m.Partition
@@ -72,27 +121,20 @@ func (s *TransferService) FromOffset(ctx context.Context, partition int32, offse
 	return transfers, errc
 }
 
-// messages fetches Kafka messages from the given partition and calls f.
-func (s *TransferService) messages(ctx context.Context, topic string, partition int32, offset int64, f func(*sarama.ConsumerMessage) error) error {
-	s.client.logger.Log("level", "debug", "msg", "messages reading started", "topic", topic, "partition", partition, "offset", offset)
-
-	pConsumer, err := s.client.consumer.ConsumePartition(topic, partition, offset)
+// FromTime returns a channel of transfers from the given partition starting at the first transfer created at or after since.
+func (s *TransferService) FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Transfer, <-chan error) {
+	offset, err := s.client.offsetForTime(s.client.copts.transferTopic, partition, since)
 	if err != nil {
-		s.client.logger.Log("level", "debug", "msg", "messages consumer not created", "err", err, "topic", topic, "partition", partition, "offset", offset)
-		return err
-	}
-	// Terminate message consuming by closing Messages channel when ctx is cancelled.
-	go func() {
-		<-ctx.Done()
-		pConsumer.Close()
-		s.client.logger.Log("level", "debug", "msg", "messages consumer closed", "err", ctx.Err(), "topic", topic, "partition", partition, "offset", offset)
-	}()
-
-	for m := range pConsumer.Messages() {
-		s.client.logger.Log("level", "debug", "msg", "message received", "body", m.Value, "topic", topic, "partition", partition, "offset", offset)
-		f(m)
+		transfers := make(chan *wallet.Transfer)
+		close(transfers)
+		errc := make(chan error, 1)
+		errc <- err
+		return transfers, errc
 	}
+	return s.FromOffset(ctx, partition, offset)
+}
 
-	s.client.logger.Log("level", "debug", "msg", "messages reading stopped", "topic", topic, "partition", partition, "offset", offset)
-	return nil
+// CommitOffset marks the transfer at offset as processed, it requires the Client's consumer group.
+func (s *TransferService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.commit(ctx, s.client.copts.transferTopic, partition, offset)
 }
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama/mocks"
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/mock"
+)
+
+func TestTransferService_CreateTransfer_StatusNotSaved(t *testing.T) {
+	sent := 0
+	transfers := make(map[string]*wallet.Transfer)
+	status := mock.TransferStatusService{
+		TransferFn: func(requestID string) (*wallet.Transfer, error) {
+			if tr, ok := transfers[requestID]; ok {
+				return tr, nil
+			}
+			return nil, wallet.ErrTransferNotFound
+		},
+		ApplyEventFn: func(e *wallet.TransferEvent) error {
+			return errors.New("disk full")
+		},
+	}
+
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func([]byte) error {
+		sent++
+		return nil
+	})
+
+	c := NewClient(WithTransferStatusService(&status))
+	c.producer = producer
+
+	// The transfer was sent, so the client isn't asked to retry it even though its status wasn't saved.
+	tr := wallet.Transfer{ID: "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", From: "Alice", To: "Bob", Amount: *apd.New(1, 0)}
+	if err := c.Transfer.CreateTransfer(context.Background(), &tr); err != nil {
+		t.Fatalf("CreateTransfer() error: %v", err)
+	}
+	if sent != 1 {
+		t.Errorf("sent %d transfers, want 1", sent)
+	}
+}
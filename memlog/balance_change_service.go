@@ -0,0 +1,79 @@
+package memlog
+
+import (
+	"context"
+	"encoding/json"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// BalanceChangeService represents an in-memory service to store balance changes.
+// Unlike Kafka the log is never compacted, so Latest reads all the changes.
+type BalanceChangeService struct {
+	client *Client
+}
+
+// CreateBalanceChange appends the balance change to the partition of the account,
+// so it lands in the partition with the same number as the account's payments.
+func (s *BalanceChangeService) CreateBalanceChange(ctx context.Context, b *wallet.BalanceChange) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	b.Partition, b.SequenceID = s.client.balances.append(b.Account, v, s.client.metadata(ctx))
+
+	s.client.logger.Log("level", "debug", "msg", "balance change created", "partition", b.Partition, "offset", b.SequenceID, "body", v)
+	return nil
+}
+
+// FromOffset returns a channel of balance changes from the given partition starting at offset.
+func (s *BalanceChangeService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.BalanceChange, <-chan error) {
+	changes := make(chan *wallet.BalanceChange)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the changes channel after read returns.
+		defer close(changes)
+
+		err := s.client.balances.read(ctx, partition, offset, func(m *message) error {
+			b := wallet.BalanceChange{}
+			if err := json.Unmarshal(m.value, &b); err != nil {
+				return err
+			}
+			b.Partition = partition
+			b.SequenceID = m.offset
+
+			select {
+			case changes <- &b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return changes, errc
+}
+
+// Latest returns the latest balance change of each account appended to the partition so far.
+func (s *BalanceChangeService) Latest(ctx context.Context, partition int32) (map[string]*wallet.BalanceChange, error) {
+	msgs, err := s.client.balances.messages(partition)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*wallet.BalanceChange)
+	for _, m := range msgs {
+		b := wallet.BalanceChange{}
+		if err = json.Unmarshal(m.value, &b); err != nil {
+			return nil, err
+		}
+		b.Partition = partition
+		b.SequenceID = m.offset
+		latest[b.Account] = &b
+	}
+	return latest, nil
+}
@@ -0,0 +1,293 @@
+// Package memlog implements wallet services on top of an in-memory partitioned log
+// and provides the Client to access them. It mimics Kafka: messages are assigned to partitions
+// by hashing their keys the same way sarama does, offsets grow sequentially within a partition,
+// and FromOffset blocks waiting for new messages until its context is cancelled.
+// It lets the transfer→payment→balance pipeline run in unit tests. The log lives in a single process,
+// so the commands can't share it: use filelog to run them without a broker.
+package memlog
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+const (
+	// DefaultPartitions is a default number of partitions of each topic.
+	DefaultPartitions = 2
+)
+
+// Errors returned when a partition is read.
+var (
+	ErrPartitionNotFound = errors.New("memlog: partition not found")
+	ErrOffsetOutOfRange  = errors.New("memlog: offset out of range")
+)
+
+// Client represents a client to the in-memory log.
+type Client struct {
+	Transfer  wallet.TransferService
+	Payment   wallet.PaymentService
+	Rejection wallet.RejectionService
+	Event     wallet.TransferEventService
+	// BalanceChange is used by accountantd to publish balances and restore them.
+	BalanceChange wallet.BalanceChangeService
+
+	logger wallet.Logger
+	// status is used to detect retried transfer requests, see WithTransferStatusService.
+	status     wallet.TransferStatusService
+	transfers  *topic
+	payments   *topic
+	rejections *topic
+	events     *topic
+	balances   *topic
+
+	copts connOption
+}
+
+// connOption holds the log settings.
+type connOption struct {
+	partitions int
+	// serviceName is kept in messages' metadata to tell which service created them.
+	serviceName string
+}
+
+// NewClient returns a new Client which provides you with
+// transfer, payment, rejection, transfer event and balance change services based on the in-memory log.
+// By default each topic has two partitions and logs are discarded.
+func NewClient(options ...ConfigOption) *Client {
+	c := Client{
+		logger: &wallet.NoopLogger{},
+		copts: connOption{
+			partitions: DefaultPartitions,
+		},
+	}
+	for _, opt := range options {
+		opt(&c)
+	}
+
+	c.transfers = newTopic("wallet.transfer_request", c.copts.partitions)
+	c.payments = newTopic("wallet.payment", c.copts.partitions)
+	c.rejections = newTopic("wallet.payment_rejection", c.copts.partitions)
+	c.events = newTopic("wallet.transfer_event", c.copts.partitions)
+	c.balances = newTopic("wallet.balance", c.copts.partitions)
+	c.Transfer = &TransferService{client: &c}
+	c.Payment = &PaymentService{client: &c}
+	c.Rejection = &RejectionService{client: &c}
+	c.Event = &TransferEventService{client: &c}
+	c.BalanceChange = &BalanceChangeService{client: &c}
+	return &c
+}
+
+// ConfigOption configures the Client.
+type ConfigOption func(*Client)
+
+// WithLogger configures a logger to debug interactions with the log.
+func WithLogger(l wallet.Logger) ConfigOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithPartitions sets a number of partitions of each topic.
+func WithPartitions(n int) ConfigOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.copts.partitions = n
+		}
+	}
+}
+
+// WithServiceName sets a name of the service which produces messages, e.g., paymentd.
+// It's kept in the messages' metadata.
+func WithServiceName(name string) ConfigOption {
+	return func(c *Client) {
+		c.copts.serviceName = name
+	}
+}
+
+// WithTransferStatusService makes TransferService detect duplicate request IDs.
+func WithTransferStatusService(s wallet.TransferStatusService) ConfigOption {
+	return func(c *Client) {
+		c.status = s
+	}
+}
+
+// Partitions returns partition numbers of a topic. All topics have the same number of partitions.
+func (c *Client) Partitions() []int32 {
+	ps := make([]int32, len(c.transfers.partitions))
+	for i := range ps {
+		ps[i] = int32(i)
+	}
+	return ps
+}
+
+// metadata returns metadata of a message being appended.
+func (c *Client) metadata(ctx context.Context) wallet.Metadata {
+	return wallet.Metadata{
+		TraceID:   wallet.TraceID(ctx),
+		Producer:  c.copts.serviceName,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// message is a value stored in a partition along with its offset and metadata.
+type message struct {
+	offset int64
+	value  []byte
+	meta   wallet.Metadata
+}
+
+// partition is an append-only sequence of messages.
+type partition struct {
+	messages []message
+	// appended is closed and replaced when a message is appended to wake up the readers.
+	appended chan struct{}
+}
+
+// topic is a partitioned log. A single mutex guards all partitions of the topic,
+// so a group of messages could be appended atomically.
+type topic struct {
+	name       string
+	mu         sync.Mutex
+	partitions []*partition
+	// committed maps a partition to the offset following the last committed one.
+	committed map[int32]int64
+}
+
+// newTopic returns a topic with n empty partitions.
+func newTopic(name string, n int) *topic {
+	t := topic{
+		name:       name,
+		partitions: make([]*partition, n),
+		committed:  make(map[int32]int64),
+	}
+	for i := range t.partitions {
+		t.partitions[i] = &partition{appended: make(chan struct{})}
+	}
+	return &t
+}
+
+// partitionFor returns a partition of the key. It is sarama's hash partitioner,
+// so a key lands in the partition with the same number as in Kafka.
+func (t *topic) partitionFor(key string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p := int32(h.Sum32()) % int32(len(t.partitions))
+	if p < 0 {
+		p = -p
+	}
+	return p
+}
+
+// append appends the value to the partition of the key and returns the partition number and the offset.
+func (t *topic) append(key string, value []byte, meta wallet.Metadata) (int32, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.appendLocked(key, value, meta)
+}
+
+// appendLocked appends the message while t.mu is held.
+func (t *topic) appendLocked(key string, value []byte, meta wallet.Metadata) (int32, int64) {
+	n := t.partitionFor(key)
+	p := t.partitions[n]
+	offset := int64(len(p.messages))
+	p.messages = append(p.messages, message{offset: offset, value: value, meta: meta})
+	close(p.appended)
+	p.appended = make(chan struct{})
+	return n, offset
+}
+
+// commit marks the message at offset as processed.
+func (t *topic) commit(partition int32, offset int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.commitLocked(partition, offset)
+}
+
+// commitLocked commits the offset while t.mu is held.
+func (t *topic) commitLocked(partition int32, offset int64) error {
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return ErrPartitionNotFound
+	}
+	t.committed[partition] = offset + 1
+	return nil
+}
+
+// offsetForTime returns the offset of the first message of the partition created at or after since.
+// The offset following the last message is returned when there is no such message.
+func (t *topic) offsetForTime(partition int32, since time.Time) (int64, error) {
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return 0, ErrPartitionNotFound
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msgs := t.partitions[partition].messages
+	for _, m := range msgs {
+		if !m.meta.CreatedAt.Before(since) {
+			return m.offset, nil
+		}
+	}
+	return int64(len(msgs)), nil
+}
+
+// messages returns a copy of the partition's messages appended so far.
+func (t *topic) messages(partition int32) ([]message, error) {
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return nil, ErrPartitionNotFound
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]message(nil), t.partitions[partition].messages...), nil
+}
+
+// read calls f for each message of the partition starting at offset.
+// It waits for new messages until ctx is cancelled, then the ctx error is returned.
+// Reading stops when f returns an error.
+func (t *topic) read(ctx context.Context, partition int32, offset int64, f func(m *message) error) error {
+	if partition < 0 || int(partition) >= len(t.partitions) {
+		return ErrPartitionNotFound
+	}
+	p := t.partitions[partition]
+
+	t.mu.Lock()
+	switch offset {
+	case wallet.OffsetOldest:
+		offset = 0
+	case wallet.OffsetNewest:
+		offset = int64(len(p.messages))
+	case wallet.OffsetCommitted:
+		offset = t.committed[partition]
+	}
+	if offset < 0 || offset > int64(len(p.messages)) {
+		t.mu.Unlock()
+		return ErrOffsetOutOfRange
+	}
+	t.mu.Unlock()
+
+	for {
+		t.mu.Lock()
+		if offset < int64(len(p.messages)) {
+			m := p.messages[offset]
+			t.mu.Unlock()
+			if err := f(&m); err != nil {
+				return err
+			}
+			offset++
+			continue
+		}
+		appended := p.appended
+		t.mu.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
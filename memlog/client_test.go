@@ -0,0 +1,146 @@
+package memlog_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/memlog"
+)
+
+// Ensure memlog services implement wallet interfaces.
+var (
+	_ wallet.TransferService      = &memlog.TransferService{}
+	_ wallet.PaymentService       = &memlog.PaymentService{}
+	_ wallet.RejectionService     = &memlog.RejectionService{}
+	_ wallet.TransferEventService = &memlog.TransferEventService{}
+	_ wallet.BalanceChangeService = &memlog.BalanceChangeService{}
+)
+
+func TestPaymentService_FromOffset(t *testing.T) {
+	c := memlog.NewClient()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	alice := wallet.Payment{ID: "1:outgoing:Alice", Account: "Alice", Amount: *apd.New(5, -1), Currency: "USD"}
+	if err := c.Payment.CreatePayment(ctx, &alice); err != nil {
+		t.Fatal(err)
+	}
+	payments, errc := c.Payment.FromOffset(ctx, alice.Partition, wallet.OffsetOldest)
+	if p := <-payments; p.ID != alice.ID || p.SequenceID != 0 {
+		t.Fatalf("payment: %s@%d, want %s@0", p.ID, p.SequenceID, alice.ID)
+	}
+
+	// FromOffset blocks until the next payment of the account is appended to the same partition.
+	again := wallet.Payment{ID: "2:outgoing:Alice", Account: "Alice", Amount: *apd.New(1, 0), Currency: "USD"}
+	go c.Payment.CreatePayment(ctx, &again)
+	p := <-payments
+	if p.ID != again.ID || p.Partition != alice.Partition || p.SequenceID != 1 {
+		t.Fatalf("payment: %s %d:%d, want %s %d:1", p.ID, p.Partition, p.SequenceID, again.ID, alice.Partition)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("error: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPaymentService_CommitOffset(t *testing.T) {
+	c := memlog.NewClient(memlog.WithPartitions(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, id := range []string{"1:incoming:Bob", "2:incoming:Bob"} {
+		p := wallet.Payment{ID: id, Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := c.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Payment.CommitOffset(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	payments, _ := c.Payment.FromOffset(ctx, 0, wallet.OffsetCommitted)
+	if p := <-payments; p.ID != "2:incoming:Bob" {
+		t.Fatalf("payment: %s, want 2:incoming:Bob", p.ID)
+	}
+}
+
+func TestPaymentService_FromTime(t *testing.T) {
+	c := memlog.NewClient(memlog.WithPartitions(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var since time.Time
+	for i, id := range []string{"1:incoming:Bob", "2:incoming:Bob", "3:incoming:Bob"} {
+		if i == 2 {
+			time.Sleep(time.Millisecond)
+			since = time.Now()
+			time.Sleep(time.Millisecond)
+		}
+		p := wallet.Payment{ID: id, Account: "Bob", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := c.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payments, _ := c.Payment.FromTime(ctx, 0, since)
+	if p := <-payments; p.ID != "3:incoming:Bob" || p.SequenceID != 2 {
+		t.Fatalf("payment: %s@%d, want 3:incoming:Bob@2", p.ID, p.SequenceID)
+	}
+
+	// Only new payments are read when all of them were created before since.
+	payments, errc := c.Payment.FromTime(ctx, 0, time.Now())
+	cancel()
+	if _, ok := <-payments; ok {
+		t.Fatal("expected no payments")
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("error: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestTransferService_FromOffset_outOfRange(t *testing.T) {
+	c := memlog.NewClient()
+	transfers, errc := c.Transfer.FromOffset(context.Background(), 0, 1)
+	if _, ok := <-transfers; ok {
+		t.Fatal("expected transfers channel to be closed")
+	}
+	if err := <-errc; err != memlog.ErrOffsetOutOfRange {
+		t.Fatalf("error: %v, want %v", err, memlog.ErrOffsetOutOfRange)
+	}
+}
+
+func TestBalanceChangeService_Latest(t *testing.T) {
+	c := memlog.NewClient(memlog.WithPartitions(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	changes := []wallet.BalanceChange{
+		{Account: "Alice", Balances: []wallet.Balance{{Currency: "USD", Amount: *apd.New(1, 0)}}, PaymentOffset: 0},
+		{Account: "Bob", Balances: []wallet.Balance{{Currency: "USD", Amount: *apd.New(2, 0)}}, PaymentOffset: 1},
+		{Account: "Alice", Balances: []wallet.Balance{{Currency: "EUR", Amount: *apd.New(3, 0)}, {Currency: "USD", Amount: *apd.New(1, 0)}}, PaymentOffset: 2},
+	}
+	for i := range changes {
+		if err := c.BalanceChange.CreateBalanceChange(ctx, &changes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := c.BalanceChange.Latest(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("accounts: %d, want 2", len(latest))
+	}
+	if b := latest["Alice"]; b.PaymentOffset != 2 || len(b.Balances) != 2 || b.Balances[0].Amount.Text('f') != "3" {
+		t.Fatalf("Alice: %+v, want 3 EUR at payment offset 2", b)
+	}
+	if b := latest["Bob"]; b.SequenceID != 1 || b.Balances[0].Amount.Text('f') != "2" {
+		t.Fatalf("Bob: %+v, want 2 USD at offset 1", b)
+	}
+}
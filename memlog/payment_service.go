@@ -0,0 +1,108 @@
+package memlog
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// PaymentService represents an in-memory service to store payment instructions.
+type PaymentService struct {
+	client *Client
+}
+
+// CreatePayment appends the payment to the partition of its account.
+func (s *PaymentService) CreatePayment(ctx context.Context, p *wallet.Payment) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	p.Partition, p.SequenceID = s.client.payments.append(p.Account, b, s.client.metadata(ctx))
+
+	s.client.logger.Log("level", "debug", "msg", "payment created", "partition", p.Partition, "offset", p.SequenceID, "body", b)
+	return nil
+}
+
+// CreateTransferPayments appends the payments created from the transfer request and commits the offset
+// of the request atomically, as kafka.PaymentService does in a transaction.
+func (s *PaymentService) CreateTransferPayments(ctx context.Context, t *wallet.Transfer, payments ...*wallet.Payment) error {
+	values := make([][]byte, len(payments))
+	for i, p := range payments {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		values[i] = b
+	}
+
+	// Topics are always locked in the same order to avoid a deadlock.
+	transfers, paymentLog := s.client.transfers, s.client.payments
+	transfers.mu.Lock()
+	defer transfers.mu.Unlock()
+	paymentLog.mu.Lock()
+	defer paymentLog.mu.Unlock()
+
+	if err := transfers.commitLocked(t.Partition, t.SequenceID); err != nil {
+		return err
+	}
+	meta := s.client.metadata(ctx)
+	for i, p := range payments {
+		p.Partition, p.SequenceID = paymentLog.appendLocked(p.Account, values[i], meta)
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "transfer payments created", "request", t.ID, "partition", t.Partition, "offset", t.SequenceID, "payments", len(payments))
+	return nil
+}
+
+// FromOffset returns a channel of payments from the given partition starting at offset.
+func (s *PaymentService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Payment, <-chan error) {
+	payments := make(chan *wallet.Payment)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the payments channel after read returns.
+		defer close(payments)
+
+		err := s.client.payments.read(ctx, partition, offset, func(m *message) error {
+			p := wallet.Payment{}
+			if err := json.Unmarshal(m.value, &p); err != nil {
+				return err
+			}
+			p.Partition = partition
+			p.SequenceID = m.offset
+			p.Meta = m.meta
+
+			select {
+			case payments <- &p:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return payments, errc
+}
+
+// FromTime returns a channel of payments from the given partition starting at the first payment created at or after since.
+func (s *PaymentService) FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Payment, <-chan error) {
+	offset, err := s.client.payments.offsetForTime(partition, since)
+	if err != nil {
+		payments := make(chan *wallet.Payment)
+		close(payments)
+		errc := make(chan error, 1)
+		errc <- err
+		return payments, errc
+	}
+	return s.FromOffset(ctx, partition, offset)
+}
+
+// CommitOffset marks the payment at offset as processed, so FromOffset with wallet.OffsetCommitted resumes after it.
+func (s *PaymentService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.payments.commit(partition, offset)
+}
@@ -0,0 +1,63 @@
+package memlog
+
+import (
+	"context"
+	"encoding/json"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// RejectionService represents an in-memory service to store payment rejections.
+type RejectionService struct {
+	client *Client
+}
+
+// CreateRejection appends the rejection to the partition of the payment's request ID,
+// so paymentd finds it in the partition with the same number as the transfer's partition.
+func (s *RejectionService) CreateRejection(ctx context.Context, r *wallet.Rejection) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	r.Partition, r.SequenceID = s.client.rejections.append(r.Payment.RequestID, b, s.client.metadata(ctx))
+
+	s.client.logger.Log("level", "debug", "msg", "rejection created", "partition", r.Partition, "offset", r.SequenceID, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of rejections from the given partition starting at offset.
+func (s *RejectionService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Rejection, <-chan error) {
+	rejections := make(chan *wallet.Rejection)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the rejections channel after read returns.
+		defer close(rejections)
+
+		err := s.client.rejections.read(ctx, partition, offset, func(m *message) error {
+			r := wallet.Rejection{}
+			if err := json.Unmarshal(m.value, &r); err != nil {
+				return err
+			}
+			r.Partition = partition
+			r.SequenceID = m.offset
+
+			select {
+			case rejections <- &r:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return rejections, errc
+}
+
+// CommitOffset marks the rejection at offset as processed, so FromOffset with wallet.OffsetCommitted resumes after it.
+func (s *RejectionService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.rejections.commit(partition, offset)
+}
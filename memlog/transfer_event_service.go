@@ -0,0 +1,57 @@
+package memlog
+
+import (
+	"context"
+	"encoding/json"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// TransferEventService represents an in-memory service to store transfer status changes.
+type TransferEventService struct {
+	client *Client
+}
+
+// CreateEvent appends the transfer event to the partition of its request ID.
+func (s *TransferEventService) CreateEvent(ctx context.Context, e *wallet.TransferEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	e.Partition, e.SequenceID = s.client.events.append(e.RequestID, b, s.client.metadata(ctx))
+
+	s.client.logger.Log("level", "debug", "msg", "transfer event created", "partition", e.Partition, "offset", e.SequenceID, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of transfer events from the given partition starting at offset.
+func (s *TransferEventService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.TransferEvent, <-chan error) {
+	events := make(chan *wallet.TransferEvent)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the events channel after read returns.
+		defer close(events)
+
+		err := s.client.events.read(ctx, partition, offset, func(m *message) error {
+			e := wallet.TransferEvent{}
+			if err := json.Unmarshal(m.value, &e); err != nil {
+				return err
+			}
+			e.Partition = partition
+			e.SequenceID = m.offset
+
+			select {
+			case events <- &e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return events, errc
+}
@@ -0,0 +1,119 @@
+package memlog
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// TransferService represents an in-memory service to store money transfer requests.
+type TransferService struct {
+	client *Client
+	// mu serializes duplicate check and appending, so concurrent retries can't both pass the check.
+	mu sync.Mutex
+}
+
+// CreateTransfer appends the transfer request to the partition of its request ID.
+// When the Client has a transfer status service, a duplicate request ID is detected
+// the same way as kafka.TransferService does.
+func (s *TransferService) CreateTransfer(ctx context.Context, t *wallet.Transfer) error {
+	if s.client.status == nil {
+		return s.createTransfer(ctx, t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orig, err := s.client.status.Transfer(t.ID)
+	switch err {
+	case nil:
+		if !orig.SameRequest(t) {
+			return wallet.ErrTransferConflict
+		}
+		*t = *orig
+		return wallet.ErrTransferExists
+	case wallet.ErrTransferNotFound:
+	default:
+		return err
+	}
+
+	if err = s.createTransfer(ctx, t); err != nil {
+		return err
+	}
+	e := wallet.TransferEvent{
+		RequestID: t.ID,
+		Status:    wallet.StatusAccepted,
+		Time:      time.Now().UTC(),
+		Transfer:  t,
+	}
+	if err = s.client.status.ApplyEvent(&e); err != nil {
+		s.client.logger.Log("level", "info", "msg", "transfer status not saved", "request", t.ID, "err", err)
+	}
+	return nil
+}
+
+// createTransfer appends the transfer to the log.
+func (s *TransferService) createTransfer(ctx context.Context, t *wallet.Transfer) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	t.Partition, t.SequenceID = s.client.transfers.append(t.ID, b, s.client.metadata(ctx))
+
+	s.client.logger.Log("level", "debug", "msg", "transfer created", "partition", t.Partition, "offset", t.SequenceID, "body", b)
+	return nil
+}
+
+// FromOffset returns a channel of transfers from the given partition starting at offset.
+func (s *TransferService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Transfer, <-chan error) {
+	transfers := make(chan *wallet.Transfer)
+	errc := make(chan error, 1)
+
+	go func() {
+		// Close the transfers channel after read returns.
+		defer close(transfers)
+
+		err := s.client.transfers.read(ctx, partition, offset, func(m *message) error {
+			t := wallet.Transfer{}
+			if err := json.Unmarshal(m.value, &t); err != nil {
+				return err
+			}
+			t.Partition = partition
+			t.SequenceID = m.offset
+			t.Meta = m.meta
+
+			select {
+			case transfers <- &t:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return transfers, errc
+}
+
+// FromTime returns a channel of transfers from the given partition starting at the first transfer created at or after since.
+func (s *TransferService) FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Transfer, <-chan error) {
+	offset, err := s.client.transfers.offsetForTime(partition, since)
+	if err != nil {
+		transfers := make(chan *wallet.Transfer)
+		close(transfers)
+		errc := make(chan error, 1)
+		errc <- err
+		return transfers, errc
+	}
+	return s.FromOffset(ctx, partition, offset)
+}
+
+// CommitOffset marks the transfer at offset as processed, so FromOffset with wallet.OffsetCommitted resumes after it.
+func (s *TransferService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	return s.client.transfers.commit(partition, offset)
+}
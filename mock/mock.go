@@ -5,6 +5,9 @@ package mock
 
 import (
 	"context"
+	"time"
+
+	"github.com/cockroachdb/apd"
 
 	wallet "github.com/marselester/distributed-payment"
 )
@@ -15,6 +18,10 @@ type TransferService struct {
 	CreateTransferCalled bool
 	FromOffsetFn         func(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Transfer, <-chan error)
 	FromOffsetCalled     bool
+	FromTimeFn           func(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Transfer, <-chan error)
+	FromTimeCalled       bool
+	CommitOffsetFn       func(ctx context.Context, partition int32, offset int64) error
+	CommitOffsetCalled   bool
 }
 
 // CreateTransfer calls CreateTransferFn and sets CreateTransferCalled = true for tests
@@ -32,3 +39,236 @@ func (s *TransferService) FromOffset(ctx context.Context, partition int32, offse
 	s.FromOffsetCalled = true
 	return s.FromOffsetFn(ctx, partition, offset)
 }
+
+// FromTime calls FromTimeFn and sets FromTimeCalled = true for tests to inspect the mock.
+func (s *TransferService) FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *wallet.Transfer, <-chan error) {
+	s.FromTimeCalled = true
+	return s.FromTimeFn(ctx, partition, since)
+}
+
+// CommitOffset calls CommitOffsetFn and sets CommitOffsetCalled = true for tests to inspect the mock.
+func (s *TransferService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	s.CommitOffsetCalled = true
+	if s.CommitOffsetFn == nil {
+		return nil
+	}
+	return s.CommitOffsetFn(ctx, partition, offset)
+}
+
+// TransferEventService is a mock that implements wallet.TransferEventService.
+type TransferEventService struct {
+	CreateEventFn     func(ctx context.Context, e *wallet.TransferEvent) error
+	CreateEventCalled bool
+	FromOffsetFn      func(ctx context.Context, partition int32, offset int64) (<-chan *wallet.TransferEvent, <-chan error)
+	FromOffsetCalled  bool
+}
+
+// CreateEvent calls CreateEventFn and sets CreateEventCalled = true for tests to inspect the mock.
+func (s *TransferEventService) CreateEvent(ctx context.Context, e *wallet.TransferEvent) error {
+	s.CreateEventCalled = true
+	if s.CreateEventFn == nil {
+		return nil
+	}
+	return s.CreateEventFn(ctx, e)
+}
+
+// FromOffset calls FromOffsetFn and sets FromOffsetCalled = true for tests to inspect the mock.
+func (s *TransferEventService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.TransferEvent, <-chan error) {
+	s.FromOffsetCalled = true
+	return s.FromOffsetFn(ctx, partition, offset)
+}
+
+// BalanceChangeService is a mock that implements wallet.BalanceChangeService.
+type BalanceChangeService struct {
+	CreateBalanceChangeFn     func(ctx context.Context, b *wallet.BalanceChange) error
+	CreateBalanceChangeCalled bool
+	FromOffsetFn              func(ctx context.Context, partition int32, offset int64) (<-chan *wallet.BalanceChange, <-chan error)
+	FromOffsetCalled          bool
+	LatestFn                  func(ctx context.Context, partition int32) (map[string]*wallet.BalanceChange, error)
+	LatestCalled              bool
+}
+
+// CreateBalanceChange calls CreateBalanceChangeFn and sets CreateBalanceChangeCalled = true for tests to inspect the mock.
+func (s *BalanceChangeService) CreateBalanceChange(ctx context.Context, b *wallet.BalanceChange) error {
+	s.CreateBalanceChangeCalled = true
+	if s.CreateBalanceChangeFn == nil {
+		return nil
+	}
+	return s.CreateBalanceChangeFn(ctx, b)
+}
+
+// FromOffset calls FromOffsetFn and sets FromOffsetCalled = true for tests to inspect the mock.
+func (s *BalanceChangeService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.BalanceChange, <-chan error) {
+	s.FromOffsetCalled = true
+	return s.FromOffsetFn(ctx, partition, offset)
+}
+
+// Latest calls LatestFn and sets LatestCalled = true for tests to inspect the mock.
+func (s *BalanceChangeService) Latest(ctx context.Context, partition int32) (map[string]*wallet.BalanceChange, error) {
+	s.LatestCalled = true
+	if s.LatestFn == nil {
+		return nil, nil
+	}
+	return s.LatestFn(ctx, partition)
+}
+
+// TransferStatusService is a mock that implements wallet.TransferStatusService.
+type TransferStatusService struct {
+	TransferFn       func(requestID string) (*wallet.Transfer, error)
+	TransferCalled   bool
+	ApplyEventFn     func(e *wallet.TransferEvent) error
+	ApplyEventCalled bool
+}
+
+// Transfer calls TransferFn and sets TransferCalled = true for tests to inspect the mock.
+func (s *TransferStatusService) Transfer(requestID string) (*wallet.Transfer, error) {
+	s.TransferCalled = true
+	if s.TransferFn == nil {
+		return nil, wallet.ErrTransferNotFound
+	}
+	return s.TransferFn(requestID)
+}
+
+// ApplyEvent calls ApplyEventFn and sets ApplyEventCalled = true for tests to inspect the mock.
+func (s *TransferStatusService) ApplyEvent(e *wallet.TransferEvent) error {
+	s.ApplyEventCalled = true
+	if s.ApplyEventFn == nil {
+		return nil
+	}
+	return s.ApplyEventFn(e)
+}
+
+// RejectionService is a mock that implements wallet.RejectionService.
+type RejectionService struct {
+	CreateRejectionFn     func(ctx context.Context, r *wallet.Rejection) error
+	CreateRejectionCalled bool
+	FromOffsetFn          func(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Rejection, <-chan error)
+	FromOffsetCalled      bool
+	CommitOffsetFn        func(ctx context.Context, partition int32, offset int64) error
+	CommitOffsetCalled    bool
+}
+
+// CreateRejection calls CreateRejectionFn and sets CreateRejectionCalled = true for tests
+// to inspect the mock.
+func (s *RejectionService) CreateRejection(ctx context.Context, r *wallet.Rejection) error {
+	s.CreateRejectionCalled = true
+	if s.CreateRejectionFn == nil {
+		return nil
+	}
+	return s.CreateRejectionFn(ctx, r)
+}
+
+// FromOffset calls FromOffsetFn and sets FromOffsetCalled = true for tests to inspect the mock.
+func (s *RejectionService) FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *wallet.Rejection, <-chan error) {
+	s.FromOffsetCalled = true
+	return s.FromOffsetFn(ctx, partition, offset)
+}
+
+// CommitOffset calls CommitOffsetFn and sets CommitOffsetCalled = true for tests to inspect the mock.
+func (s *RejectionService) CommitOffset(ctx context.Context, partition int32, offset int64) error {
+	s.CommitOffsetCalled = true
+	if s.CommitOffsetFn == nil {
+		return nil
+	}
+	return s.CommitOffsetFn(ctx, partition, offset)
+}
+
+// DedupService is a mock that implements wallet.DedupService.
+type DedupService struct {
+	HasSeenFn     func(id string) (bool, error)
+	HasSeenCalled bool
+	SaveFn        func(id string) error
+	SaveCalled    bool
+}
+
+// HasSeen calls HasSeenFn and sets HasSeenCalled = true for tests to inspect the mock.
+func (s *DedupService) HasSeen(id string) (bool, error) {
+	s.HasSeenCalled = true
+	if s.HasSeenFn == nil {
+		return false, nil
+	}
+	return s.HasSeenFn(id)
+}
+
+// Save calls SaveFn and sets SaveCalled = true for tests to inspect the mock.
+func (s *DedupService) Save(id string) error {
+	s.SaveCalled = true
+	if s.SaveFn == nil {
+		return nil
+	}
+	return s.SaveFn(id)
+}
+
+// BalanceService is a mock that implements wallet.BalanceService.
+type BalanceService struct {
+	BalanceFn        func(account, currency string) (apd.Decimal, error)
+	BalanceCalled    bool
+	BalancesFn       func(account string) ([]wallet.Balance, error)
+	BalancesCalled   bool
+	SetBalanceFn     func(account, currency string, bal apd.Decimal) error
+	SetBalanceCalled bool
+}
+
+// Balance calls BalanceFn and sets BalanceCalled = true for tests to inspect the mock.
+func (s *BalanceService) Balance(account, currency string) (apd.Decimal, error) {
+	s.BalanceCalled = true
+	if s.BalanceFn == nil {
+		return apd.Decimal{}, nil
+	}
+	return s.BalanceFn(account, currency)
+}
+
+// Balances calls BalancesFn and sets BalancesCalled = true for tests to inspect the mock.
+func (s *BalanceService) Balances(account string) ([]wallet.Balance, error) {
+	s.BalancesCalled = true
+	if s.BalancesFn == nil {
+		return nil, nil
+	}
+	return s.BalancesFn(account)
+}
+
+// SetBalance calls SetBalanceFn and sets SetBalanceCalled = true for tests to inspect the mock.
+func (s *BalanceService) SetBalance(account, currency string, bal apd.Decimal) error {
+	s.SetBalanceCalled = true
+	if s.SetBalanceFn == nil {
+		return nil
+	}
+	return s.SetBalanceFn(account, currency, bal)
+}
+
+// LedgerService is a mock that implements wallet.LedgerService.
+type LedgerService struct {
+	ApplyPaymentFn     func(p *wallet.Payment, bal apd.Decimal) error
+	ApplyPaymentCalled bool
+	SetOffsetFn        func(offset int64) error
+	SetOffsetCalled    bool
+	OffsetFn           func() (int64, error)
+	OffsetCalled       bool
+}
+
+// ApplyPayment calls ApplyPaymentFn and sets ApplyPaymentCalled = true for tests to inspect the mock.
+func (s *LedgerService) ApplyPayment(p *wallet.Payment, bal apd.Decimal) error {
+	s.ApplyPaymentCalled = true
+	if s.ApplyPaymentFn == nil {
+		return nil
+	}
+	return s.ApplyPaymentFn(p, bal)
+}
+
+// SetOffset calls SetOffsetFn and sets SetOffsetCalled = true for tests to inspect the mock.
+func (s *LedgerService) SetOffset(offset int64) error {
+	s.SetOffsetCalled = true
+	if s.SetOffsetFn == nil {
+		return nil
+	}
+	return s.SetOffsetFn(offset)
+}
+
+// Offset calls OffsetFn and sets OffsetCalled = true for tests to inspect the mock.
+func (s *LedgerService) Offset() (int64, error) {
+	s.OffsetCalled = true
+	if s.OffsetFn == nil {
+		return 0, wallet.ErrOffsetNotFound
+	}
+	return s.OffsetFn()
+}
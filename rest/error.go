@@ -8,6 +8,8 @@ import (
 const (
 	errInvalidJSON = wallet.Error("problems parsing JSON")
 	errInternal    = wallet.Error("internal error")
+	// errNoStatus is returned when the server doesn't keep track of transfer statuses.
+	errNoStatus = wallet.Error("transfer status is not available")
 )
 
 // apiError defines wallet API errors. For example, amount validation error could have
@@ -7,26 +7,27 @@ import (
 
 	"github.com/cockroachdb/apd"
 	"github.com/go-chi/chi"
+	uuid "github.com/satori/go.uuid"
 
 	wallet "github.com/marselester/distributed-payment"
 )
 
 // Server represents an HTTP API handler for wallet services. It wraps a TransferService
-// so we can provide different implementations, e.g., Kafka or a mock.
+// and a TransferStatusService so we can provide different implementations, e.g., Kafka or a mock.
 type Server struct {
 	*chi.Mux
 	logger          wallet.Logger
 	transferService wallet.TransferService
+	statusService   wallet.TransferStatusService
 	wopts           walletOption
 }
 
 // walletOption configures wallet API server.
 type walletOption struct {
-	maxDigits     uint32
-	decimalPlaces uint32
-	decimalCtx    *apd.Context
-
-	minAmount apd.Decimal
+	maxDigits  uint32
+	decimalCtx *apd.Context
+	// currencies maps supported currency codes to their decimal places.
+	currencies map[string]uint32
 }
 
 // NewServer registers HTTP handlers and returns a new server.
@@ -35,26 +36,45 @@ func NewServer(options ...ConfigOption) *Server {
 		Mux:    chi.NewRouter(),
 		logger: &wallet.NoopLogger{},
 		wopts: walletOption{
-			maxDigits:     wallet.DecimalMaxDigits,
-			decimalPlaces: wallet.DecimalPlaces,
-			decimalCtx:    apd.BaseContext.WithPrecision(wallet.DecimalMaxDigits),
+			maxDigits:  wallet.DecimalMaxDigits,
+			decimalCtx: apd.BaseContext.WithPrecision(wallet.DecimalMaxDigits),
+			currencies: make(map[string]uint32, len(wallet.Currencies)),
 		},
 	}
-	min, _, _ := apd.NewFromString("0.01")
-	srv.wopts.minAmount = *min
+	for code, places := range wallet.Currencies {
+		srv.wopts.currencies[code] = places
+	}
 
 	for _, opt := range options {
 		opt(&srv)
 	}
 
+	srv.Use(traceRequest)
 	srv.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"status": "ok"}`))
 	})
 	srv.Post("/api/v1/transfers", srv.handlePostTransfer())
+	srv.Get("/api/v1/transfers/{id}", srv.handleGetTransfer())
 
 	return &srv
 }
 
+// TraceHeader is an HTTP header of a trace ID which lets a client find payments caused by its request.
+const TraceHeader = "X-Trace-Id"
+
+// traceRequest puts the request's trace ID into the request context, so the storages could pass it
+// along with a transfer. The ID is taken from TraceHeader or generated, and it's sent back in the response.
+func traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID := r.Header.Get(TraceHeader)
+		if traceID == "" {
+			traceID = uuid.NewV4().String()
+		}
+		w.Header().Set(TraceHeader, traceID)
+		next.ServeHTTP(w, r.WithContext(wallet.WithTraceID(r.Context(), traceID)))
+	})
+}
+
 // ConfigOption configures the API server.
 type ConfigOption func(*Server)
 
@@ -72,11 +92,27 @@ func WithTransferService(s wallet.TransferService) ConfigOption {
 	}
 }
 
-// WithPrecision lets you set the decimal precision.
+// WithTransferStatusService configures server to use a transfer status service.
+func WithTransferStatusService(s wallet.TransferStatusService) ConfigOption {
+	return func(srv *Server) {
+		srv.statusService = s
+	}
+}
+
+// WithPrecision lets you set the decimal precision and decimal places of wallet.DefaultCurrency,
+// see WithCurrency for other currencies.
 func WithPrecision(maxDigits, decimalPlaces uint32) ConfigOption {
 	return func(srv *Server) {
 		srv.wopts.maxDigits = maxDigits
-		srv.wopts.decimalPlaces = decimalPlaces
 		srv.wopts.decimalCtx = apd.BaseContext.WithPrecision(maxDigits)
+		srv.wopts.currencies[wallet.DefaultCurrency] = decimalPlaces
+	}
+}
+
+// WithCurrency adds a supported currency or overrides decimal places of a known one,
+// see wallet.Currencies.
+func WithCurrency(code string, decimalPlaces uint32) ConfigOption {
+	return func(srv *Server) {
+		srv.wopts.currencies[code] = decimalPlaces
 	}
 }
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/cockroachdb/apd"
+	"github.com/go-chi/chi"
 	uuid "github.com/satori/go.uuid"
 
 	wallet "github.com/marselester/distributed-payment"
@@ -12,6 +14,8 @@ import (
 
 // handlePostTransfer handles requests to create a new money transfer.
 // When there is malformed JSON request, 400 status is returned.
+// A retried request gets the original transfer with 200 status and Idempotent-Replayed header,
+// and 409 status is returned when the request ID was already used for a different transfer.
 func (s *Server) handlePostTransfer() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -29,6 +33,10 @@ func (s *Server) handlePostTransfer() http.HandlerFunc {
 			s.handleError(w, err, http.StatusBadRequest)
 			return
 		}
+		if err := s.validateTransferCurrency(&t); err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
 		if err := s.validateTransferAmount(&t); err != nil {
 			s.handleError(w, err, http.StatusBadRequest)
 			return
@@ -46,7 +54,16 @@ func (s *Server) handlePostTransfer() http.HandlerFunc {
 				s.handleError(w, err, http.StatusInternalServerError)
 			}
 		case wallet.ErrTransferExists:
-			s.handleError(w, err, http.StatusBadRequest)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusOK)
+			if err = json.NewEncoder(w).Encode(&t); err != nil {
+				s.handleError(w, err, http.StatusInternalServerError)
+			}
+		case wallet.ErrTransferConflict:
+			s.handleError(w, apiError{
+				Message: err.Error(),
+				Code:    "request_id_conflict",
+			}, http.StatusConflict)
 		default:
 			s.logger.Log("level", "debug", "msg", "transfer not created", "handler", "PostTransfer", "err", err)
 			s.handleError(w, err, http.StatusInternalServerError)
@@ -54,6 +71,38 @@ func (s *Server) handlePostTransfer() http.HandlerFunc {
 	}
 }
 
+// handleGetTransfer handles requests to get a money transfer with its current status.
+// When the transfer is not found, 404 status is returned.
+// 501 status is returned when the server has no transfer status service.
+func (s *Server) handleGetTransfer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if s.statusService == nil {
+			s.handleError(w, errNoStatus, http.StatusNotImplemented)
+			return
+		}
+
+		t := wallet.Transfer{ID: chi.URLParam(r, "id")}
+		if err := s.validateTransferRequestID(&t); err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		tr, err := s.statusService.Transfer(t.ID)
+		switch err {
+		case nil:
+			if err = json.NewEncoder(w).Encode(tr); err != nil {
+				s.handleError(w, err, http.StatusInternalServerError)
+			}
+		case wallet.ErrTransferNotFound:
+			s.handleError(w, err, http.StatusNotFound)
+		default:
+			s.logger.Log("level", "debug", "msg", "transfer not found", "handler", "GetTransfer", "err", err)
+			s.handleError(w, err, http.StatusInternalServerError)
+		}
+	}
+}
+
 // validateTransferRequestID validates whether transfer request ID is UUID.
 func (s *Server) validateTransferRequestID(t *wallet.Transfer) error {
 	u, err := uuid.FromString(t.ID)
@@ -79,11 +128,27 @@ func (s *Server) validateTransferSender(t *wallet.Transfer) error {
 	return nil
 }
 
+// validateTransferCurrency validates the transfer currency which defaults to wallet.DefaultCurrency.
+func (s *Server) validateTransferCurrency(t *wallet.Transfer) error {
+	t.Currency = strings.ToUpper(strings.TrimSpace(t.Currency))
+	if t.Currency == "" {
+		t.Currency = wallet.DefaultCurrency
+	}
+	if _, ok := s.wopts.currencies[t.Currency]; !ok {
+		return apiError{
+			Message: "transfer currency is not supported",
+			Code:    "currency_invalid",
+		}
+	}
+	return nil
+}
+
 // validateTransferAmount validates transfer amount and quantizes it
-// according to wallet precision.
+// according to decimal places of the transfer currency, e.g., the smallest USD amount is 0.01.
 func (s *Server) validateTransferAmount(t *wallet.Transfer) error {
-	min := s.wopts.minAmount
-	if t.Amount.Cmp(&min) == -1 {
+	places := s.wopts.currencies[t.Currency]
+	min := apd.New(1, -int32(places))
+	if t.Amount.Cmp(min) == -1 {
 		return apiError{
 			Message: "ensure this value is greater than " + min.Text('f'),
 			Code:    "amount_lt_min",
@@ -92,7 +157,7 @@ func (s *Server) validateTransferAmount(t *wallet.Transfer) error {
 
 	// Inexact and Rounded conditions might occur when an amount is quantized.
 	// Those conditions are not part of apd.DefaultTraps, so err will be nil.
-	if res, err := s.wopts.decimalCtx.Quantize(&t.Amount, &t.Amount, int32(-s.wopts.decimalPlaces)); err != nil {
+	if res, err := s.wopts.decimalCtx.Quantize(&t.Amount, &t.Amount, -int32(places)); err != nil {
 		return apiError{
 			Message: "invalid amount: " + res.String(),
 			Code:    "amount_invalid",
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	wallet "github.com/marselester/distributed-payment"
 	"github.com/marselester/distributed-payment/mock"
@@ -112,6 +113,98 @@ func TestTransferService_CreateTransfer_ErrInternal(t *testing.T) {
 	}
 }
 
+func TestTransferService_CreateTransfer_TraceID(t *testing.T) {
+	const traceID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	var gotTraceID string
+	m := mock.TransferService{
+		CreateTransferFn: func(ctx context.Context, t *wallet.Transfer) error {
+			gotTraceID = wallet.TraceID(ctx)
+			return nil
+		},
+	}
+	srv := rest.NewServer(
+		rest.WithTransferService(&m),
+	)
+
+	r := httptest.NewRequest("POST", "/api/v1/transfers", strings.NewReader(`{
+		"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"from": "Alice",
+		"amount": "1",
+		"to": "Bob"
+	}`))
+	r.Header.Set(rest.TraceHeader, traceID)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	if gotTraceID != traceID {
+		t.Fatalf("trace ID: %q, want %q", gotTraceID, traceID)
+	}
+	if h := w.Result().Header.Get(rest.TraceHeader); h != traceID {
+		t.Fatalf("trace header: %q, want %q", h, traceID)
+	}
+}
+
+func TestTransferService_CreateTransfer_Duplicate(t *testing.T) {
+	m := mock.TransferService{
+		CreateTransferFn: func(_ context.Context, t *wallet.Transfer) error {
+			if t.To != "Bob" {
+				return wallet.ErrTransferConflict
+			}
+			t.Status = wallet.StatusAccepted
+			return wallet.ErrTransferExists
+		},
+	}
+	tests := []struct {
+		name       string
+		statusCode int
+		replayed   string
+		body       string
+		want       string
+	}{
+		{
+			name:       "retry",
+			statusCode: http.StatusOK,
+			replayed:   "true",
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "1", "to": "Bob"}`,
+			want:       `{"request_id":"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11","from":"Alice","amount":"1.00","currency":"USD","to":"Bob","status":"accepted"}` + "\n",
+		},
+		{
+			name:       "conflict",
+			statusCode: http.StatusConflict,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "1", "to": "John"}`,
+			want:       `{"message":"transfer request ID is already used by another transfer","code":"request_id_conflict"}` + "\n",
+		},
+	}
+
+	srv := rest.NewServer(
+		rest.WithTransferService(&m),
+	)
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/transfers", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			body := w.Body.String()
+			if body != tc.want {
+				t.Fatalf("body: %s, want %s", body, tc.want)
+			}
+
+			resp := w.Result()
+			if resp.StatusCode != tc.statusCode {
+				t.Fatalf("status code: %d, want %d", resp.StatusCode, tc.statusCode)
+			}
+
+			replayed := resp.Header.Get("Idempotent-Replayed")
+			if replayed != tc.replayed {
+				t.Fatalf("idempotent replayed: %q, want %q", replayed, tc.replayed)
+			}
+		})
+	}
+}
+
 func TestTransferService_CreateTransfer_RequestIDValidation(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -382,6 +475,76 @@ func TestTransferService_CreateTransfer_AmountValidation(t *testing.T) {
 	}
 }
 
+func TestTransferService_CreateTransfer_CurrencyValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       string
+	}{
+		{
+			name:       "currency missing",
+			statusCode: http.StatusCreated,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "1", "to": "Bob"}`,
+			want:       `"amount":"1.00","currency":"USD"`,
+		},
+		{
+			name:       "currency lowercase",
+			statusCode: http.StatusCreated,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "1", "currency": "eur", "to": "Bob"}`,
+			want:       `"amount":"1.00","currency":"EUR"`,
+		},
+		{
+			name:       "currency unknown",
+			statusCode: http.StatusBadRequest,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "1", "currency": "XYZ", "to": "Bob"}`,
+			want:       `{"message":"transfer currency is not supported","code":"currency_invalid"}`,
+		},
+		{
+			name:       "JPY quantized",
+			statusCode: http.StatusCreated,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "100.4", "currency": "JPY", "to": "Bob"}`,
+			want:       `"amount":"100","currency":"JPY"`,
+		},
+		{
+			name:       "JPY lt min",
+			statusCode: http.StatusBadRequest,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "0.5", "currency": "JPY", "to": "Bob"}`,
+			want:       `{"message":"ensure this value is greater than 1","code":"amount_lt_min"}`,
+		},
+		{
+			name:       "KWD eq min",
+			statusCode: http.StatusCreated,
+			body:       `{"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "from": "Alice", "amount": "0.001", "currency": "KWD", "to": "Bob"}`,
+			want:       `"amount":"0.001","currency":"KWD"`,
+		},
+	}
+
+	srv := rest.NewServer(
+		rest.WithTransferService(&mock.TransferService{}),
+	)
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest("POST", "/api/v1/transfers", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			body := w.Body.String()
+			if !strings.Contains(body, tc.want) {
+				t.Fatalf("body: %s, want %s", body, tc.want)
+			}
+
+			resp := w.Result()
+			if resp.StatusCode != tc.statusCode {
+				t.Fatalf("status code: %d, want %d", resp.StatusCode, tc.statusCode)
+			}
+		})
+	}
+}
+
 func TestTransferService_CreateTransfer_RecipientValidation(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -441,3 +604,117 @@ func TestTransferService_CreateTransfer_RecipientValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestTransferService_GetTransfer(t *testing.T) {
+	debited := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	m := mock.TransferStatusService{
+		TransferFn: func(requestID string) (*wallet.Transfer, error) {
+			if requestID != "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11" {
+				return nil, wallet.ErrTransferNotFound
+			}
+			return &wallet.Transfer{
+				ID:     requestID,
+				From:   "Alice",
+				To:     "Bob",
+				Status: wallet.StatusDebited,
+				Timestamps: map[wallet.TransferStatus]time.Time{
+					wallet.StatusDebited: debited,
+				},
+			}, nil
+		},
+	}
+	tests := []struct {
+		name       string
+		statusCode int
+		id         string
+		want       string
+	}{
+		{
+			name:       "found",
+			statusCode: http.StatusOK,
+			id:         "A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11",
+			want:       `"status":"debited","timestamps":{"debited":"2018-05-01T10:00:00Z"}`,
+		},
+		{
+			name:       "not found",
+			statusCode: http.StatusNotFound,
+			id:         "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			want:       `{"message":"transfer not found"}`,
+		},
+		{
+			name:       "invalid id",
+			statusCode: http.StatusBadRequest,
+			id:         "Alice",
+			want:       `{"message":"transfer request ID must be valid UUID","code":"request_id_invalid"}`,
+		},
+	}
+
+	srv := rest.NewServer(
+		rest.WithTransferStatusService(&m),
+	)
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest("GET", "/api/v1/transfers/"+tc.id, nil)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			body := w.Body.String()
+			if !strings.Contains(body, tc.want) {
+				t.Fatalf("body: %s, want %s", body, tc.want)
+			}
+
+			resp := w.Result()
+			if resp.StatusCode != tc.statusCode {
+				t.Fatalf("status code: %d, want %d", resp.StatusCode, tc.statusCode)
+			}
+
+			ct := resp.Header.Get("Content-Type")
+			if ct != "application/json" {
+				t.Fatalf("content type: %q, want application/json", ct)
+			}
+		})
+	}
+}
+
+func TestTransferService_GetTransfer_NoStatusService(t *testing.T) {
+	srv := rest.NewServer()
+	r := httptest.NewRequest("GET", "/api/v1/transfers/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("status code: %d, want %d", resp.StatusCode, http.StatusNotImplemented)
+	}
+	want := `{"message":"transfer status is not available"}`
+	if body := strings.TrimSpace(w.Body.String()); body != want {
+		t.Fatalf("body: %s, want %s", body, want)
+	}
+}
+
+func TestTransferService_CreateTransfer_Precision(t *testing.T) {
+	srv := rest.NewServer(
+		rest.WithTransferService(&mock.TransferService{}),
+		rest.WithPrecision(wallet.DecimalMaxDigits, 3),
+	)
+	body := `{
+		"request_id": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"from": "Alice",
+		"amount": "1.0009",
+		"to": "Bob"
+	}`
+	r := httptest.NewRequest("POST", "/api/v1/transfers", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	if resp := w.Result(); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status code: %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	want := `"amount":"1.001"`
+	if got := w.Body.String(); !strings.Contains(got, want) {
+		t.Fatalf("body: %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package rocks
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/apd"
+	"github.com/pkg/errors"
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// BalanceService represents a RocksDB service to store account balances.
+// A balance is stored as a decimal string, e.g., "-0.50", under the account and currency key.
+type BalanceService struct {
+	client *Client
+}
+
+// balanceKey returns a key of the account balance in the currency, e.g., "balance/Alice/USD".
+func balanceKey(account, currency string) []byte {
+	return []byte(balancePrefix + account + "/" + currency)
+}
+
+// isCurrencyCode reports whether s looks like a currency code, e.g., "USD".
+// Currency codes are upper-cased when transfers are validated.
+func isCurrencyCode(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Balance returns the account balance in the currency. Zero is returned if the account is not found.
+// Balances saved before currencies were introduced are treated as wallet.DefaultCurrency balances.
+func (s *BalanceService) Balance(account, currency string) (apd.Decimal, error) {
+	var bal apd.Decimal
+	value, err := s.client.get(balanceKey(account, currency))
+	if err != nil {
+		return bal, err
+	}
+	if value == nil && currency == wallet.DefaultCurrency {
+		if value, err = s.client.get([]byte(balancePrefix + account)); err != nil {
+			return bal, err
+		}
+	}
+
+	if value == nil {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not find balance", "account", account, "currency", currency)
+		return bal, nil
+	}
+
+	if _, _, err = bal.SetString(string(value)); err != nil {
+		return bal, errors.Wrapf(err, "rocks balance of %s %s", account, currency)
+	}
+	s.client.logger.Log("level", "debug", "msg", "rocks found balance", "account", account, "currency", currency, "balance", value)
+	return bal, nil
+}
+
+// Balances returns the account balances in all currencies sorted by currency.
+// A balance saved before currencies were introduced is returned as wallet.DefaultCurrency balance
+// unless the account has a newer one.
+func (s *BalanceService) Balances(account string) ([]wallet.Balance, error) {
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+
+	var bb []wallet.Balance
+	hasDefault := false
+	it := s.client.db.NewIterator(ro)
+	defer it.Close()
+	prefix := balanceKey(account, "")
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		k, v := it.Key(), it.Value()
+		b := wallet.Balance{Currency: string(k.Data()[len(prefix):])}
+		// The prefix matches balances of sub-accounts as well, e.g., "balance/Team/QA/USD" of Team/QA.
+		if !isCurrencyCode(b.Currency) {
+			k.Free()
+			v.Free()
+			continue
+		}
+		_, _, err := b.Amount.SetString(string(v.Data()))
+		k.Free()
+		v.Free()
+		if err != nil {
+			return nil, errors.Wrapf(err, "rocks balance of %s %s", account, b.Currency)
+		}
+		bb = append(bb, b)
+		hasDefault = hasDefault || b.Currency == wallet.DefaultCurrency
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	if !hasDefault {
+		value, err := s.client.get([]byte(balancePrefix + account))
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			b := wallet.Balance{Currency: wallet.DefaultCurrency}
+			if _, _, err = b.Amount.SetString(string(value)); err != nil {
+				return nil, errors.Wrapf(err, "rocks balance of %s", account)
+			}
+			bb = append(bb, b)
+			sort.Slice(bb, func(i, j int) bool { return bb[i].Currency < bb[j].Currency })
+		}
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "rocks found balances", "account", account, "currencies", len(bb))
+	return bb, nil
+}
+
+// SetBalance persists the account balance in the currency.
+func (s *BalanceService) SetBalance(account, currency string, bal apd.Decimal) error {
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+
+	value := bal.Text('f')
+	err := s.client.db.Put(wo, balanceKey(account, currency), []byte(value))
+	if err != nil {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not save balance", "account", account, "currency", currency, "balance", value, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "rocks saved balance", "account", account, "currency", currency, "balance", value)
+	return nil
+}
@@ -0,0 +1,34 @@
+package rocks_test
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/rocks"
+)
+
+// Ensure rocks.BalanceService implements wallet.BalanceService interface.
+var _ wallet.BalanceService = &rocks.BalanceService{}
+
+func TestBalanceService_Balances_subAccount(t *testing.T) {
+	store := openStore(t)
+	for account, amount := range map[string]int64{"Team": 1, "Team/QA": 2} {
+		if err := store.Balance.SetBalance(account, "USD", *apd.New(amount, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Team/QA's balance isn't mistaken for Team's balance in "QA/USD" currency.
+	bb, err := store.Balance.Balances("Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bb) != 1 || bb[0].Currency != "USD" || bb[0].Amount.Text('f') != "1" {
+		t.Errorf("Team balances: %v, want [USD 1]", bb)
+	}
+	if bb, _ = store.Balance.Balances("Team/QA"); len(bb) != 1 || bb[0].Amount.Text('f') != "2" {
+		t.Errorf("Team/QA balances: %v, want [USD 2]", bb)
+	}
+}
@@ -0,0 +1,171 @@
+// Package rocks implements wallet services and provides the Client to access them.
+// The services share the same RocksDB database, so their keys are prefixed
+// to keep them apart, e.g., "dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:outgoing:Alice" or "balance/Alice/USD".
+package rocks
+
+import (
+	"time"
+
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+const (
+	// DefaultDB is a default RocksDB database name.
+	DefaultDB = "dedup.db"
+	// DefaultCacheSize is a default size of the block cache in bytes.
+	DefaultCacheSize = 8 << 20
+)
+
+// Key prefixes of the services.
+const (
+	dedupPrefix = "dedup/"
+	// balancePrefix is followed by an account and a currency, see balanceKey.
+	balancePrefix = "balance/"
+	// transferPrefix is used by transfer status service, e.g., "transfer/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11".
+	transferPrefix = "transfer/"
+	// offsetKey is where the ledger keeps the offset of the last applied payment.
+	offsetKey = "offset"
+	// movedPrefix is followed by an account which was copied to the database by MoveAccounts,
+	// e.g., "moved/Alice".
+	movedPrefix = "moved/"
+)
+
+// Client represents a client to the underlying RocksDB database.
+type Client struct {
+	Dedup   wallet.DedupService
+	Balance wallet.BalanceService
+	Ledger  wallet.LedgerService
+	// TransferStatus is used by transfer-server, the rest of the services are used by accountantd.
+	TransferStatus wallet.TransferStatusService
+
+	logger wallet.Logger
+	db     *gorocksdb.DB
+	cache  *gorocksdb.Cache
+
+	copts connOption
+}
+
+// connOption holds connection settings.
+type connOption struct {
+	dbname string
+	// ttl is how long dedup records are kept, zero means forever.
+	ttl time.Duration
+	// cacheSize is a size of the block cache in bytes.
+	cacheSize uint64
+}
+
+// NewClient returns a new Client which provides you with
+// dedup, balance, ledger and transfer status services based on RocksDB.
+// By default logs are discarded.
+func NewClient(options ...ConfigOption) *Client {
+	c := Client{
+		logger: &wallet.NoopLogger{},
+		copts: connOption{
+			dbname:    DefaultDB,
+			cacheSize: DefaultCacheSize,
+		},
+	}
+	c.Dedup = &DedupService{client: &c}
+	c.Balance = &BalanceService{client: &c}
+	c.Ledger = &LedgerService{client: &c}
+	c.TransferStatus = &TransferStatusService{client: &c}
+
+	for _, opt := range options {
+		opt(&c)
+	}
+	return &c
+}
+
+// ConfigOption configures the Client.
+type ConfigOption func(*Client)
+
+// WithDB sets the database name.
+func WithDB(dbname string) ConfigOption {
+	return func(c *Client) {
+		c.copts.dbname = dbname
+	}
+}
+
+// WithCacheSize sets a size of the block cache in bytes which keeps uncompressed blocks in memory.
+// Each database has its own cache, e.g., accountantd opens a database per partition.
+func WithCacheSize(size uint64) ConfigOption {
+	return func(c *Client) {
+		c.copts.cacheSize = size
+	}
+}
+
+// WithLogger configures a logger to debug interactions with RocksDB.
+func WithLogger(l wallet.Logger) ConfigOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithTTL sets how long dedup records are kept, e.g., 168h (Kafka's default log retention).
+// It should match the topic retention, because duplicates can't be replayed once
+// the messages aged out. Expired records are purged during compactions.
+func WithTTL(ttl time.Duration) ConfigOption {
+	return func(c *Client) {
+		c.copts.ttl = ttl
+	}
+}
+
+// Open opens a connection to RocksDB.
+// Make sure you call Close to clean up resources.
+func (c *Client) Open() error {
+	c.cache = gorocksdb.NewLRUCache(c.copts.cacheSize)
+	bbto := gorocksdb.NewDefaultBlockBasedTableOptions()
+	bbto.SetBlockCache(c.cache)
+	opts := gorocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(bbto)
+	opts.SetCreateIfMissing(true)
+	if c.copts.ttl > 0 {
+		opts.SetCompactionFilter(&ttlFilter{ttl: c.copts.ttl})
+	}
+
+	var err error
+	c.db, err = gorocksdb.OpenDb(opts, c.copts.dbname)
+	if err != nil {
+		c.logger.Log("level", "debug", "msg", "rocks not opened", "db", c.copts.dbname, "err", err)
+		return err
+	}
+	c.logger.Log("level", "debug", "msg", "rocks opened", "db", c.copts.dbname)
+	return nil
+}
+
+// Purge removes expired dedup records by compacting them with TTL compaction filter.
+// RocksDB runs compactions automatically when enough data is written,
+// so call it periodically if the database is rarely updated.
+func (c *Client) Purge() {
+	c.db.CompactRange(gorocksdb.Range{
+		Start: []byte(dedupPrefix),
+		Limit: prefixEnd(dedupPrefix),
+	})
+	c.logger.Log("level", "debug", "msg", "rocks purged expired records", "db", c.copts.dbname)
+}
+
+// get returns a copy of the value stored at key or nil if the key is not found.
+func (c *Client) get(key []byte) ([]byte, error) {
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+
+	value, err := c.db.Get(ro, key)
+	if err != nil {
+		return nil, err
+	}
+	defer value.Free()
+
+	if len(value.Data()) == 0 {
+		return nil, nil
+	}
+	return append([]byte(nil), value.Data()...), nil
+}
+
+// Close closes the database and releases its block cache.
+func (c *Client) Close() {
+	c.db.Close()
+	c.cache.Destroy()
+	c.logger.Log("level", "debug", "msg", "rocks closed", "db", c.copts.dbname)
+}
@@ -0,0 +1,79 @@
+package rocks
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/pkg/errors"
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// LedgerService represents a RocksDB service to apply payments.
+// It writes the payment ID, the account balance and the payment's offset
+// in one WriteBatch, therefore either all of them are persisted or none.
+type LedgerService struct {
+	client *Client
+}
+
+// ApplyPayment atomically saves the payment ID, the new balance of the payment's account
+// in the payment's currency and the offset of the payment.
+func (s *LedgerService) ApplyPayment(p *wallet.Payment, bal apd.Decimal) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	wb.Put([]byte(dedupPrefix+p.ID), dedupValue(time.Now()))
+	wb.Put(balanceKey(p.Account, p.Currency), []byte(bal.Text('f')))
+	wb.Put([]byte(offsetKey), []byte(strconv.FormatInt(p.SequenceID, 10)))
+
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+
+	if err := s.client.db.Write(wo, wb); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not apply payment", "payment", p.ID, "offset", p.SequenceID, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "rocks applied payment", "payment", p.ID, "offset", p.SequenceID, "account", p.Account, "currency", p.Currency, "balance", bal.Text('f'))
+	return nil
+}
+
+// SetOffset saves the offset of a payment which didn't change a balance.
+func (s *LedgerService) SetOffset(offset int64) error {
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+
+	if err := s.client.db.Put(wo, []byte(offsetKey), []byte(strconv.FormatInt(offset, 10))); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not save offset", "offset", offset, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "rocks saved offset", "offset", offset)
+	return nil
+}
+
+// Offset returns the offset of the last applied payment.
+// wallet.ErrOffsetNotFound is returned when no payments were applied yet.
+func (s *LedgerService) Offset() (int64, error) {
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+
+	value, err := s.client.db.Get(ro, []byte(offsetKey))
+	if err != nil {
+		return 0, err
+	}
+	defer value.Free()
+
+	if len(value.Data()) == 0 {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not find offset")
+		return 0, wallet.ErrOffsetNotFound
+	}
+
+	offset, err := strconv.ParseInt(string(value.Data()), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "rocks offset")
+	}
+	s.client.logger.Log("level", "debug", "msg", "rocks found offset", "offset", offset)
+	return offset, nil
+}
@@ -0,0 +1,36 @@
+package rocks_test
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/rocks"
+)
+
+// Ensure rocks.LedgerService implements wallet.LedgerService interface.
+var _ wallet.LedgerService = &rocks.LedgerService{}
+
+func TestLedgerService_SetOffset(t *testing.T) {
+	store := openStore(t)
+	p := wallet.Payment{ID: "1:outgoing:Alice", Account: "Alice", Currency: "USD", SequenceID: 3}
+	if err := store.Ledger.ApplyPayment(&p, *apd.New(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A duplicate payment moves the offset, but not the balance.
+	if err := store.Ledger.SetOffset(4); err != nil {
+		t.Fatal(err)
+	}
+	offset, err := store.Ledger.Offset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 4 {
+		t.Errorf("offset: %d, want 4", offset)
+	}
+	if bal, _ := store.Balance.Balance("Alice", "USD"); bal.Text('f') != "1" {
+		t.Errorf("balance: %s, want 1", bal.Text('f'))
+	}
+}
@@ -0,0 +1,202 @@
+package rocks
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+// ErrBalanceConflict is returned by MoveAccounts when the destination database already has
+// a different balance of the account, e.g., it has applied the account's payments since.
+var ErrBalanceConflict = errors.New("rocks: balance conflict")
+
+// Move describes balances and payment IDs of an account moved to another database by MoveAccounts.
+type Move struct {
+	Account string
+	// DB is a name of the database where the account moved.
+	DB         string
+	Balances   int
+	PaymentIDs int
+}
+
+// record is a key-value pair of an account's balance or payment ID.
+type record struct {
+	key, value []byte
+	isBalance  bool
+}
+
+// MoveAccounts moves balances and payment IDs of the accounts to the databases returned by dst,
+// e.g., when accounts are assigned to other partitions of wallet.payment topic. The accounts for which
+// dst returns nil or c stay. The ledger offset stays as well, because it belongs to c's partition.
+// Records of an account are copied in one WriteBatch along with a marker of the account,
+// and then they are deleted from c. An interrupted move can be run again: the marked accounts
+// aren't copied twice, their records are only deleted from c.
+// A balance is never overwritten with a different value, ErrBalanceConflict is returned instead,
+// even if the account is marked.
+// When dryRun is true, the moves are only reported.
+func (c *Client) MoveAccounts(dst func(account string) *Client, dryRun bool) ([]Move, error) {
+	records, err := c.accountRecords(dst)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]string, 0, len(records))
+	for account := range records {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	mm := make([]Move, 0, len(accounts))
+	for _, account := range accounts {
+		to := dst(account)
+		m := Move{Account: account, DB: to.copts.dbname}
+		for _, r := range records[account] {
+			if r.isBalance {
+				m.Balances++
+			} else {
+				m.PaymentIDs++
+			}
+		}
+
+		if err := checkBalances(to, records[account]); err != nil {
+			return nil, err
+		}
+		mm = append(mm, m)
+		if dryRun {
+			continue
+		}
+
+		marker, err := to.get(movedKey(account))
+		if err != nil {
+			return nil, err
+		}
+		if marker == nil {
+			if err = c.copyAccount(to, account, records[account]); err != nil {
+				return nil, err
+			}
+		} else {
+			c.logger.Log("level", "debug", "msg", "rocks found moved account", "account", account, "db", to.copts.dbname)
+		}
+		if err = c.deleteAccount(account, records[account]); err != nil {
+			return nil, err
+		}
+	}
+	return mm, nil
+}
+
+// accountRecords returns balances and payment IDs of the accounts which move to other databases.
+func (c *Client) accountRecords(dst func(account string) *Client) (map[string][]record, error) {
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+
+	records := make(map[string][]record)
+	it := c.db.NewIterator(ro)
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		k, v := it.Key(), it.Value()
+		r := record{
+			key:   append([]byte(nil), k.Data()...),
+			value: append([]byte(nil), v.Data()...),
+		}
+		k.Free()
+		v.Free()
+
+		var account string
+		if account, r.isBalance = recordAccount(string(r.key)); account == "" {
+			continue
+		}
+		if to := dst(account); to == nil || to == c {
+			continue
+		}
+		records[account] = append(records[account], r)
+	}
+	return records, it.Err()
+}
+
+// checkBalances returns ErrBalanceConflict if the destination database has a different balance of the account.
+func checkBalances(to *Client, records []record) error {
+	for _, r := range records {
+		if !r.isBalance {
+			continue
+		}
+		existing, err := to.get(r.key)
+		if err != nil {
+			return err
+		}
+		if existing != nil && string(existing) != string(r.value) {
+			return errors.Wrapf(ErrBalanceConflict, "%s in %s", r.key, to.copts.dbname)
+		}
+	}
+	return nil
+}
+
+// copyAccount atomically writes the account's records and its marker to the destination database.
+func (c *Client) copyAccount(to *Client, account string, records []record) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	for _, r := range records {
+		wb.Put(r.key, r.value)
+	}
+	wb.Put(movedKey(account), []byte(c.copts.dbname))
+
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+	if err := to.db.Write(wo, wb); err != nil {
+		return errors.Wrapf(err, "rocks copy %s to %s", account, to.copts.dbname)
+	}
+	c.logger.Log("level", "debug", "msg", "rocks copied account", "account", account, "db", to.copts.dbname, "records", len(records))
+	return nil
+}
+
+// deleteAccount atomically deletes the account's records from c along with the marker
+// left by an earlier move of the account to c, so the account can be moved back later.
+func (c *Client) deleteAccount(account string, records []record) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	for _, r := range records {
+		wb.Delete(r.key)
+	}
+	wb.Delete(movedKey(account))
+
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+	if err := c.db.Write(wo, wb); err != nil {
+		return errors.Wrapf(err, "rocks delete %s", account)
+	}
+	c.logger.Log("level", "debug", "msg", "rocks deleted account", "account", account, "db", c.copts.dbname, "records", len(records))
+	return nil
+}
+
+// movedKey returns a key of the marker of the account moved to the database, e.g., "moved/Alice".
+func movedKey(account string) []byte {
+	return []byte(movedPrefix + account)
+}
+
+// recordAccount returns an account of a balance or a dedup record.
+// An empty account is returned for the rest of the records, e.g., the ledger offset.
+// Both kinds of keys are parsed from their fixed parts, so an account may contain "/" and ":".
+func recordAccount(key string) (account string, isBalance bool) {
+	switch {
+	case strings.HasPrefix(key, balancePrefix):
+		// A balance key ends with a currency code, see balanceKey. Balances saved before currencies
+		// were introduced don't have a currency, e.g., "balance/Alice" or "balance/Alice/Bob".
+		account = key[len(balancePrefix):]
+		if i := strings.LastIndex(account, "/"); i >= 0 && isCurrencyCode(account[i+1:]) {
+			account = account[:i]
+		}
+		return account, true
+	case strings.HasPrefix(key, dedupPrefix):
+		// Payment ID consists of request ID, direction and account, see wallet.PaymentID.
+		// Neither request ID (UUID) nor direction contains ":", so the rest is the account.
+		parts := strings.SplitN(key[len(dedupPrefix):], ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			return "", false
+		}
+		switch parts[1] {
+		case "incoming", "outgoing", "reversal":
+			return parts[2], false
+		}
+	}
+	return "", false
+}
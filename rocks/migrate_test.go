@@ -0,0 +1,170 @@
+package rocks
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+	"github.com/pkg/errors"
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+func TestRecordAccount(t *testing.T) {
+	tt := map[string]struct {
+		key       string
+		account   string
+		isBalance bool
+	}{
+		"balance":                        {"balance/Alice/USD", "Alice", true},
+		"balance without currency":       {"balance/Alice", "Alice", true},
+		"balance slash":                  {"balance/Team/QA/USD", "Team/QA", true},
+		"balance slash without currency": {"balance/Team/qa", "Team/qa", true},
+		"balance colon":                  {"balance/a0eebc99:outgoing:Bob/EUR", "a0eebc99:outgoing:Bob", true},
+		"payment ID":                     {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:outgoing:Alice", "Alice", false},
+		"payment ID reversal":            {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:reversal:Bob", "Bob", false},
+		"payment ID colon":               {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:incoming:Bob:1", "Bob:1", false},
+		"payment ID slash":               {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:incoming:Team/QA/USD", "Team/QA/USD", false},
+		"unknown direction":              {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:sideways:Bob", "", false},
+		"no account":                     {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:incoming:", "", false},
+		"not payment ID":                 {"dedup/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "", false},
+		"offset":                         {"offset", "", false},
+		"marker":                         {"moved/Alice", "", false},
+		"transfer":                       {"transfer/a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "", false},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			account, isBalance := recordAccount(tc.key)
+			if account != tc.account || isBalance != tc.isBalance {
+				t.Errorf("recordAccount(%q) = %q, %t, want %q, %t", tc.key, account, isBalance, tc.account, tc.isBalance)
+			}
+		})
+	}
+}
+
+func openTestDB(t *testing.T, name string) *Client {
+	c := NewClient(WithDB(filepath.Join(t.TempDir(), name)))
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// applyPayments applies the account's payments in USD, the last balance is kept.
+func applyPayments(t *testing.T, c *Client, account string, ids ...string) {
+	for i, id := range ids {
+		p := wallet.Payment{ID: wallet.PaymentID(id, "incoming", account), Account: account, Currency: "USD", SequenceID: int64(i)}
+		if err := c.Ledger.ApplyPayment(&p, *apd.New(int64(i+1), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClient_MoveAccounts(t *testing.T) {
+	src, dst := openTestDB(t, "accountant0.db"), openTestDB(t, "accountant1.db")
+	applyPayments(t, src, "Alice", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")
+	applyPayments(t, src, "Team/QA", "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	to := func(account string) *Client {
+		if account == "Team/QA" {
+			return dst
+		}
+		return src
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		moves, err := src.MoveAccounts(to, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(moves) != 1 || moves[0].Account != "Team/QA" || moves[0].Balances != 1 || moves[0].PaymentIDs != 2 {
+			t.Errorf("moves (dry run %t): %+v, want Team/QA with 1 balance and 2 payment IDs", dryRun, moves)
+		}
+	}
+
+	bal, err := dst.Balance.Balance("Team/QA", "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal.Text('f') != "2" {
+		t.Errorf("moved balance: %s, want 2", bal.Text('f'))
+	}
+	if bal, _ = src.Balance.Balance("Team/QA", "USD"); !bal.IsZero() {
+		t.Errorf("balance left in source: %s", bal.Text('f'))
+	}
+	if bal, _ = src.Balance.Balance("Alice", "USD"); bal.Text('f') != "1" {
+		t.Errorf("Alice balance: %s, want 1", bal.Text('f'))
+	}
+	if seen, _ := dst.Dedup.HasSeen(wallet.PaymentID("6ba7b811-9dad-11d1-80b4-00c04fd430c8", "incoming", "Team/QA")); !seen {
+		t.Error("payment ID not moved")
+	}
+	// The ledger offset belongs to the source partition.
+	if _, err = dst.Ledger.Offset(); err != wallet.ErrOffsetNotFound {
+		t.Errorf("destination offset error: %v, want %v", err, wallet.ErrOffsetNotFound)
+	}
+
+	// Nothing is left to move, and the account can be moved back.
+	if moves, _ := src.MoveAccounts(to, false); len(moves) != 0 {
+		t.Errorf("moves: %+v, want none", moves)
+	}
+	moves, err := dst.MoveAccounts(func(string) *Client { return src }, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 {
+		t.Errorf("moves back: %+v, want Team/QA", moves)
+	}
+	if bal, _ = src.Balance.Balance("Team/QA", "USD"); bal.Text('f') != "2" {
+		t.Errorf("balance moved back: %s, want 2", bal.Text('f'))
+	}
+}
+
+func TestClient_MoveAccounts_interrupted(t *testing.T) {
+	src, dst := openTestDB(t, "accountant0.db"), openTestDB(t, "accountant1.db")
+	applyPayments(t, src, "Bob", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")
+	records, err := src.accountRecords(func(string) *Client { return dst })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The account was copied, but the move was interrupted before it was deleted from the source.
+	if err = src.copyAccount(dst, "Bob", records["Bob"]); err != nil {
+		t.Fatal(err)
+	}
+	// A payment ID is removed from the copy to see whether the account is copied again.
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+	if err = dst.db.Delete(wo, []byte(dedupPrefix+wallet.PaymentID("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "incoming", "Bob"))); err != nil {
+		t.Fatal(err)
+	}
+
+	moves, err := src.MoveAccounts(func(string) *Client { return dst }, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 || moves[0].Account != "Bob" {
+		t.Errorf("moves: %+v, want Bob", moves)
+	}
+	if seen, _ := dst.Dedup.HasSeen(wallet.PaymentID("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "incoming", "Bob")); seen {
+		t.Error("marked account copied again")
+	}
+	if bal, _ := src.Balance.Balance("Bob", "USD"); !bal.IsZero() {
+		t.Errorf("balance left in source: %s", bal.Text('f'))
+	}
+}
+
+func TestClient_MoveAccounts_conflict(t *testing.T) {
+	src, dst := openTestDB(t, "accountant0.db"), openTestDB(t, "accountant1.db")
+	applyPayments(t, src, "Bob", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")
+	applyPayments(t, dst, "Bob", "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	_, err := src.MoveAccounts(func(string) *Client { return dst }, false)
+	if errors.Cause(err) != ErrBalanceConflict {
+		t.Fatalf("MoveAccounts() error: %v, want %v", err, ErrBalanceConflict)
+	}
+	if bal, _ := src.Balance.Balance("Bob", "USD"); bal.Text('f') != "1" {
+		t.Errorf("source balance: %s, want 1", bal.Text('f'))
+	}
+}
@@ -0,0 +1,124 @@
+package rocks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// Recovery describes repairs made by Recover.
+type Recovery struct {
+	// Missing are IDs of payments which were found in the partition, but not in the database.
+	Missing []string
+	// Extra are IDs of payments which were found in the database, but not in the partition.
+	// They are kept, since they might have aged out of the partition or been moved from another database,
+	// see MoveAccounts.
+	Extra []string
+}
+
+// Recover repairs dedup records in the database based on the partition of payments
+// which is the source of truth (inspired by Segment's dedupe worker).
+// The partition is scanned from the oldest offset up to the last applied payment,
+// but not past the newest offset (the offset of the next payment appended to the partition),
+// e.g., see kafka.Client.OffsetRange. Set a deadline on ctx in case the scan can't reach the end.
+// Every payment found must be in the database, the missing ones are saved.
+// The database's payments which are absent in the partition are logged as extra.
+func (c *Client) Recover(ctx context.Context, ps wallet.PaymentService, partition int32, oldest, newest int64) (Recovery, error) {
+	var r Recovery
+	last, err := c.Ledger.Offset()
+	switch err {
+	case nil:
+	case wallet.ErrOffsetNotFound:
+		// No payments were applied, hence all dedup records are extra.
+		last = -1
+	default:
+		return r, err
+	}
+	if last > newest-1 {
+		last = newest - 1
+	}
+
+	ids, err := paymentIDs(ctx, ps, partition, oldest, last)
+	if err != nil {
+		return r, err
+	}
+	c.logger.Log("level", "debug", "msg", "rocks recovery scanned partition", "partition", partition, "oldest", oldest, "offset", last, "payments", len(ids))
+
+	for id := range ids {
+		seen, err := c.Dedup.HasSeen(id)
+		if err != nil {
+			return r, err
+		}
+		if seen {
+			continue
+		}
+		if err = c.Dedup.Save(id); err != nil {
+			return r, err
+		}
+		r.Missing = append(r.Missing, id)
+		c.logger.Log("level", "info", "msg", "rocks repaired missing payment", "payment", id)
+	}
+
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+	it := c.db.NewIterator(ro)
+	defer it.Close()
+	prefix := []byte(dedupPrefix)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Key()
+		id := string(key.Data()[len(prefix):])
+		key.Free()
+		if ids[id] {
+			continue
+		}
+		r.Extra = append(r.Extra, id)
+		c.logger.Log("level", "info", "msg", "rocks found extra payment", "payment", id)
+	}
+	return r, it.Err()
+}
+
+// paymentIDs reads the partition from the oldest offset up to the last offset (inclusive)
+// and returns IDs of the payments found.
+func paymentIDs(ctx context.Context, ps wallet.PaymentService, partition int32, oldest, last int64) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	// The partition is empty, or payments up to the last offset have aged out.
+	if last < 0 || oldest > last {
+		return ids, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	payments, errc := ps.FromOffset(ctx, partition, oldest)
+
+	reached := false
+	for p := range payments {
+		if p.SequenceID > last {
+			reached = true
+			break
+		}
+
+		// Payments created before IDs were introduced don't have them.
+		if p.ID == "" {
+			p.ID = wallet.PaymentID(p.RequestID, p.Direction, p.Account)
+		}
+		ids[p.ID] = true
+
+		if p.SequenceID == last {
+			reached = true
+			break
+		}
+	}
+	cancel()
+	if err := <-errc; err != nil && (err != context.Canceled || !reached) {
+		return nil, err
+	}
+
+	// The stream could be interrupted, e.g., the program was stopped by Ctrl+C.
+	if !reached {
+		return nil, fmt.Errorf("partition %d scan stopped before reaching offset %d", partition, last)
+	}
+	return ids, nil
+}
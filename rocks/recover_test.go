@@ -0,0 +1,135 @@
+package rocks_test
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/memlog"
+	"github.com/marselester/distributed-payment/rocks"
+)
+
+func openStore(t *testing.T) *rocks.Client {
+	store := rocks.NewClient(rocks.WithDB(filepath.Join(t.TempDir(), "dedup0.db")))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestClient_Recover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	log := memlog.NewClient(memlog.WithPartitions(1))
+	var payments []*wallet.Payment
+	for _, id := range []string{"1:outgoing:Alice", "2:outgoing:Alice", "3:outgoing:Alice"} {
+		p := wallet.Payment{ID: id, Account: "Alice", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := log.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+		payments = append(payments, &p)
+	}
+
+	// The database was restored from a backup which has the last payment,
+	// but not the earlier ones, and it has a payment moved from another database.
+	store := openStore(t)
+	if err := store.Ledger.ApplyPayment(payments[2], *apd.New(3, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Dedup.Save("4:incoming:Bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Recover(ctx, log.Payment, 0, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(r.Missing)
+	if len(r.Missing) != 2 || r.Missing[0] != "1:outgoing:Alice" || r.Missing[1] != "2:outgoing:Alice" {
+		t.Errorf("missing: %v, want [1:outgoing:Alice 2:outgoing:Alice]", r.Missing)
+	}
+	if len(r.Extra) != 1 || r.Extra[0] != "4:incoming:Bob" {
+		t.Errorf("extra: %v, want [4:incoming:Bob]", r.Extra)
+	}
+
+	for _, id := range []string{"1:outgoing:Alice", "2:outgoing:Alice", "3:outgoing:Alice", "4:incoming:Bob"} {
+		seen, err := store.Dedup.HasSeen(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !seen {
+			t.Errorf("%s not found", id)
+		}
+	}
+}
+
+func TestClient_Recover_partitionAhead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	log := memlog.NewClient(memlog.WithPartitions(1))
+	var payments []*wallet.Payment
+	for _, id := range []string{"1:outgoing:Alice", "2:outgoing:Alice"} {
+		p := wallet.Payment{ID: id, Account: "Alice", Amount: *apd.New(1, 0), Currency: "USD"}
+		if err := log.Payment.CreatePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+		payments = append(payments, &p)
+	}
+
+	// Payments following the last applied one aren't scanned.
+	store := openStore(t)
+	if err := store.Ledger.ApplyPayment(payments[0], *apd.New(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Recover(ctx, log.Payment, 0, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Missing) != 0 || len(r.Extra) != 0 {
+		t.Errorf("recovery: %+v, want none", r)
+	}
+}
+
+func TestClient_Recover_emptyPartition(t *testing.T) {
+	tt := map[string]struct {
+		oldest, newest int64
+	}{
+		"empty":    {0, 0},
+		"aged out": {10, 10},
+		"behind":   {10, 12},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			// The database has applied payments up to offset 5 which aren't in the partition anymore.
+			store := openStore(t)
+			p := wallet.Payment{ID: "1:outgoing:Alice", Account: "Alice", Currency: "USD", SequenceID: 5}
+			if err := store.Ledger.ApplyPayment(&p, *apd.New(1, 0)); err != nil {
+				t.Fatal(err)
+			}
+
+			log := memlog.NewClient(memlog.WithPartitions(1))
+			r, err := store.Recover(ctx, log.Payment, 0, tc.oldest, tc.newest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Missing) != 0 {
+				t.Errorf("missing: %v, want none", r.Missing)
+			}
+			if len(r.Extra) != 1 || r.Extra[0] != p.ID {
+				t.Errorf("extra: %v, want [%s]", r.Extra, p.ID)
+			}
+		})
+	}
+}
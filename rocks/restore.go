@@ -0,0 +1,67 @@
+package rocks
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// Restore fills an empty database with the latest balances of the partition's accounts
+// kept by the balance change service, e.g., a log-compacted topic, so the payments don't have to be replayed.
+// The offset of the latest payment which changed a balance is saved as the last applied one,
+// balances and the offset are written atomically. Payment IDs aren't restored.
+// Nothing is done when the database has applied payments already.
+// The number of restored accounts is returned.
+func (c *Client) Restore(ctx context.Context, bs wallet.BalanceChangeService, partition int32) (int, error) {
+	switch _, err := c.Ledger.Offset(); err {
+	case nil:
+		c.logger.Log("level", "debug", "msg", "rocks restore skipped", "db", c.copts.dbname, "partition", partition)
+		return 0, nil
+	case wallet.ErrOffsetNotFound:
+	default:
+		return 0, err
+	}
+
+	changes, err := bs.Latest(ctx, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	var (
+		n    int
+		last int64 = -1
+	)
+	for _, b := range changes {
+		// The change belongs to another partition of payments, e.g., topics have different number of partitions.
+		if b.PaymentPartition != partition {
+			c.logger.Log("level", "debug", "msg", "rocks restore skipped account", "account", b.Account, "payment_partition", b.PaymentPartition, "partition", partition)
+			continue
+		}
+		for _, bal := range b.Balances {
+			wb.Put(balanceKey(b.Account, bal.Currency), []byte(bal.Amount.Text('f')))
+		}
+		if b.PaymentOffset > last {
+			last = b.PaymentOffset
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	wb.Put([]byte(offsetKey), []byte(strconv.FormatInt(last, 10)))
+
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+	if err = c.db.Write(wo, wb); err != nil {
+		c.logger.Log("level", "debug", "msg", "rocks did not restore balances", "partition", partition, "err", err)
+		return 0, err
+	}
+
+	c.logger.Log("level", "info", "msg", "rocks restored balances", "partition", partition, "accounts", n, "offset", last)
+	return n, nil
+}
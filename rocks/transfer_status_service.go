@@ -0,0 +1,74 @@
+package rocks
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/tecbot/gorocksdb"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+// TransferStatusService represents a RocksDB service to keep track of transfers' statuses.
+// A transfer is stored as JSON along with its status and timestamps.
+type TransferStatusService struct {
+	client *Client
+	// mu serializes read-modify-write of transfers, since events are applied concurrently.
+	mu sync.Mutex
+}
+
+// Transfer returns the transfer with its current status.
+// wallet.ErrTransferNotFound is returned when there were no events of the transfer.
+func (s *TransferStatusService) Transfer(requestID string) (*wallet.Transfer, error) {
+	ro := gorocksdb.NewDefaultReadOptions()
+	defer ro.Destroy()
+
+	value, err := s.client.db.Get(ro, []byte(transferPrefix+requestID))
+	if err != nil {
+		return nil, err
+	}
+	defer value.Free()
+
+	if len(value.Data()) == 0 {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not find transfer", "request", requestID)
+		return nil, wallet.ErrTransferNotFound
+	}
+
+	var t wallet.Transfer
+	if err = json.Unmarshal(value.Data(), &t); err != nil {
+		return nil, err
+	}
+	s.client.logger.Log("level", "debug", "msg", "rocks found transfer", "request", requestID, "status", t.Status)
+	return &t, nil
+}
+
+// ApplyEvent updates the transfer status based on the event.
+func (s *TransferStatusService) ApplyEvent(e *wallet.TransferEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.Transfer(e.RequestID)
+	switch err {
+	case nil:
+	case wallet.ErrTransferNotFound:
+		t = &wallet.Transfer{}
+	default:
+		return err
+	}
+	t.ApplyEvent(e)
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	wo := gorocksdb.NewDefaultWriteOptions()
+	defer wo.Destroy()
+
+	if err = s.client.db.Put(wo, []byte(transferPrefix+e.RequestID), b); err != nil {
+		s.client.logger.Log("level", "debug", "msg", "rocks did not save transfer", "request", e.RequestID, "err", err)
+		return err
+	}
+
+	s.client.logger.Log("level", "debug", "msg", "rocks saved transfer", "request", e.RequestID, "status", t.Status)
+	return nil
+}
@@ -0,0 +1,9 @@
+package rocks_test
+
+import (
+	wallet "github.com/marselester/distributed-payment"
+	"github.com/marselester/distributed-payment/rocks"
+)
+
+// Ensure rocks.TransferStatusService implements wallet.TransferStatusService interface.
+var _ wallet.TransferStatusService = &rocks.TransferStatusService{}
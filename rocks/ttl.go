@@ -0,0 +1,57 @@
+package rocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// dedupValue returns a value of a dedup record which is a Unix time (seconds)
+// when the record was created encoded as big-endian uint64.
+func dedupValue(now time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(now.Unix()))
+	return b
+}
+
+// isExpired reports whether the dedup record is older than ttl.
+// Records without a timestamp never expire, e.g., they were saved before TTL was introduced.
+func isExpired(value []byte, ttl time.Duration, now time.Time) bool {
+	if ttl <= 0 || len(value) != 8 {
+		return false
+	}
+	created := time.Unix(int64(binary.BigEndian.Uint64(value)), 0)
+	return now.Sub(created) > ttl
+}
+
+// prefixEnd returns the smallest key which is greater than all the keys with the given prefix,
+// e.g., "dedup0" for "dedup/". Trailing 0xFF bytes can't be incremented, so they are dropped.
+// Nil is returned when there is no such key, e.g., all bytes are 0xFF, meaning the range is unbounded.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// ttlFilter is a compaction filter which removes expired dedup records.
+type ttlFilter struct {
+	ttl time.Duration
+}
+
+// Name returns the name of the compaction filter.
+func (f *ttlFilter) Name() string {
+	return "wallet.DedupTTL"
+}
+
+// Filter removes the record if it's an expired dedup record.
+func (f *ttlFilter) Filter(level int, key, val []byte) (remove bool, newVal []byte) {
+	if !bytes.HasPrefix(key, []byte(dedupPrefix)) {
+		return false, nil
+	}
+	return isExpired(val, f.ttl, time.Now()), nil
+}
@@ -0,0 +1,54 @@
+package rocks
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestIsExpired(t *testing.T) {
+	now := time.Unix(1551448800, 0)
+	tt := map[string]struct {
+		value []byte
+		ttl   time.Duration
+		want  bool
+	}{
+		"fresh":           {dedupValue(now.Add(-time.Minute)), time.Hour, false},
+		"exactly ttl old": {dedupValue(now.Add(-time.Hour)), time.Hour, false},
+		"older than ttl":  {dedupValue(now.Add(-time.Hour - time.Second)), time.Hour, true},
+		"ttl disabled":    {dedupValue(now.Add(-24 * time.Hour)), 0, false},
+		"negative ttl":    {dedupValue(now.Add(-24 * time.Hour)), -time.Hour, false},
+		"no timestamp":    {[]byte{}, time.Hour, false},
+		"short value":     {[]byte{1, 2, 3}, time.Hour, false},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			if got := isExpired(tc.value, tc.ttl, now); got != tc.want {
+				t.Errorf("isExpired() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tt := map[string]struct {
+		prefix string
+		want   []byte
+	}{
+		"dedup":         {"dedup/", []byte("dedup0")},
+		"trailing 0xFF": {"a\xff", []byte("b")},
+		"many 0xFF":     {"a\xfe\xff\xff", []byte("a\xff")},
+		"all 0xFF":      {"\xff\xff", nil},
+		"empty":         {"", nil},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			got := prefixEnd(tc.prefix)
+			if !bytes.Equal(got, tc.want) || (got == nil) != (tc.want == nil) {
+				t.Errorf("prefixEnd(%q) = %q, want %q", tc.prefix, got, tc.want)
+			}
+		})
+	}
+}
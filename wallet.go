@@ -4,6 +4,7 @@ package wallet
 
 import (
 	"context"
+	"time"
 
 	"github.com/cockroachdb/apd"
 )
@@ -11,8 +12,38 @@ import (
 const (
 	// DecimalMaxDigits is a decimal precision (defaults to 28 as in Python).
 	DecimalMaxDigits = 28
-	// DecimalPlaces defines how many decimal places are used to quantize a number.
+	// DecimalPlaces defines how many decimal places are used to quantize a number
+	// of the default currency.
 	DecimalPlaces = 2
+	// DefaultCurrency is assumed when a transfer or a payment has no currency,
+	// e.g., it was created before currencies were introduced.
+	DefaultCurrency = "USD"
+)
+
+// Currencies maps ISO 4217 currency codes to the number of decimal places (minor units)
+// an amount is quantized to, e.g., 0.01 is the smallest USD amount and 1 is the smallest JPY amount.
+var Currencies = map[string]uint32{
+	"USD": DecimalPlaces,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"CAD": 2,
+	"RUB": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Offsets which have a special meaning when reading a partition.
+const (
+	// OffsetNewest stands for the offset of the message that will be appended to a partition next.
+	OffsetNewest int64 = -1
+	// OffsetOldest stands for the oldest offset available in a partition.
+	OffsetOldest int64 = -2
+	// OffsetCommitted stands for the offset following the last committed one, see CommitOffset of the services.
+	// A partition is read from the oldest offset when nothing was committed yet.
+	OffsetCommitted int64 = -3
 )
 
 // Transfer is a customer request to send money.
@@ -22,45 +53,258 @@ type Transfer struct {
 	// From which account to send money.
 	From   string      `json:"from"`
 	Amount apd.Decimal `json:"amount"`
+	// Currency is ISO 4217 code of the amount, e.g., USD.
+	Currency string `json:"currency"`
 	// To is a transfer recipient account.
 	To string `json:"to"`
+	// Status is the current stage of the transfer known to TransferStatusService.
+	Status TransferStatus `json:"status,omitempty"`
+	// Timestamps tell when the transfer reached the statuses.
+	Timestamps map[TransferStatus]time.Time `json:"timestamps,omitempty"`
 	// Partition is a number of a partition where the transfer request was stored.
 	Partition int32 `json:"-"`
 	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
 	SequenceID int64 `json:"-"`
+	// Meta describes the stored transfer request, it's set when the transfer is read back.
+	Meta Metadata `json:"-"`
+}
+
+// Metadata describes where a stored message came from, e.g., Kafka message headers.
+type Metadata struct {
+	// TraceID correlates the message with the request which caused it, see WithTraceID.
+	TraceID string
+	// Producer is a name of the service which created the message, e.g., paymentd.
+	Producer string
+	// CreatedAt is when the message was created.
+	CreatedAt time.Time
+	// SchemaVersion is a version of the message's schema.
+	SchemaVersion int
+}
+
+// SameRequest reports whether the transfers were requested with the same sender, amount, currency and recipient.
+// It helps to tell a retried request from a different request that reuses the request ID.
+func (t *Transfer) SameRequest(o *Transfer) bool {
+	return t.From == o.From && t.To == o.To && t.Amount.Cmp(&o.Amount) == 0 && t.Currency == o.Currency
+}
+
+// TransferStatus is a stage of a transfer lifecycle.
+type TransferStatus string
+
+// Transfer statuses.
+const (
+	// StatusAccepted means the transfer request was stored and its payments are created.
+	StatusAccepted TransferStatus = "accepted"
+	// StatusDebited means the sender's outgoing payment was applied.
+	StatusDebited TransferStatus = "debited"
+	// StatusCredited means the recipient's incoming payment was applied.
+	StatusCredited TransferStatus = "credited"
+	// StatusCompleted means both payments were applied.
+	StatusCompleted TransferStatus = "completed"
+	// StatusRejected means the sender's payment was rejected, so the recipient's payment is reversed.
+	StatusRejected TransferStatus = "rejected"
+)
+
+// ApplyEvent updates the transfer status based on the event.
+// Events can be duplicated and arrive in any order, e.g., Bob's payment could be credited
+// before Alice's payment is debited, hence the status is derived from the timestamps.
+func (t *Transfer) ApplyEvent(e *TransferEvent) {
+	if t.ID == "" {
+		t.ID = e.RequestID
+	}
+	if e.Transfer != nil && t.From == "" {
+		t.From = e.Transfer.From
+		t.Amount = e.Transfer.Amount
+		t.Currency = e.Transfer.Currency
+		t.To = e.Transfer.To
+	}
+
+	if t.Timestamps == nil {
+		t.Timestamps = make(map[TransferStatus]time.Time)
+	}
+	// The earliest time is kept when an event is duplicated.
+	if ts, ok := t.Timestamps[e.Status]; !ok || e.Time.Before(ts) {
+		t.Timestamps[e.Status] = e.Time
+	}
+
+	debited, isDebited := t.Timestamps[StatusDebited]
+	credited, isCredited := t.Timestamps[StatusCredited]
+	_, isRejected := t.Timestamps[StatusRejected]
+	switch {
+	case isRejected:
+		t.Status = StatusRejected
+	case isDebited && isCredited:
+		t.Status = StatusCompleted
+		t.Timestamps[StatusCompleted] = debited
+		if credited.After(debited) {
+			t.Timestamps[StatusCompleted] = credited
+		}
+	case isDebited:
+		t.Status = StatusDebited
+	case isCredited:
+		t.Status = StatusCredited
+	default:
+		t.Status = StatusAccepted
+	}
+}
+
+// TransferEvent is a change of a transfer status reported by paymentd or accountantd.
+type TransferEvent struct {
+	RequestID string         `json:"request_id"`
+	Status    TransferStatus `json:"status"`
+	Time      time.Time      `json:"time"`
+	// Transfer is set when the transfer is accepted, so the transfer details are known along with its status.
+	Transfer *Transfer `json:"transfer,omitempty"`
+	// Partition is a number of a partition where the event was stored.
+	Partition int32 `json:"-"`
+	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
+	SequenceID int64 `json:"-"`
 }
 
 // Payment is an instruction that affects account balance.
 // For example, outgoing 0.5 payment from Alice's account.
 type Payment struct {
-	// RequestID is a client request ID from Transfer entity for duplicate suppression.
+	// ID is an idempotency key of the payment, see PaymentID.
+	ID string `json:"id"`
+	// RequestID is a client request ID from Transfer entity.
 	RequestID string `json:"request_id"`
 	// Account where the payment belongs to.
 	Account string `json:"account"`
 	// Direction defines whether payment is incoming or outgoing.
 	Direction string      `json:"direction"`
 	Amount    apd.Decimal `json:"amount"`
+	// Currency is ISO 4217 code of the amount, balances are kept per account and currency.
+	Currency string `json:"currency"`
+	// Counterparty is the other account of the transfer, e.g., Bob is Alice's counterparty
+	// when she sends him money.
+	Counterparty string `json:"counterparty,omitempty"`
+	// Reverses is an ID of a payment which is compensated by this payment.
+	// For example, Bob's incoming payment is reversed when Alice's outgoing payment is rejected.
+	Reverses string `json:"reverses,omitempty"`
 	// Partition is a number of a partition where the payment was stored.
 	Partition int32 `json:"-"`
 	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
 	SequenceID int64 `json:"-"`
+	// Meta describes the stored payment, it's set when the payment is read back.
+	Meta Metadata `json:"-"`
+}
+
+// Rejection is an event that a payment was declined, e.g., it would overdraw Alice's account.
+type Rejection struct {
+	// Payment is the rejected payment.
+	Payment Payment `json:"payment"`
+	// Reason explains why the payment was rejected, e.g., "insufficient funds".
+	Reason string `json:"reason"`
+	// Partition is a number of a partition where the rejection was stored.
+	Partition int32 `json:"-"`
+	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
+	SequenceID int64 `json:"-"`
+}
+
+// Balance is an account balance in a currency.
+type Balance struct {
+	// Currency is ISO 4217 code of the amount, e.g., USD.
+	Currency string      `json:"currency"`
+	Amount   apd.Decimal `json:"amount"`
+}
+
+// BalanceChange is an event that a payment changed an account balance.
+// It carries all balances of the account, so the latest change of the account
+// describes its current state, e.g., when older changes are removed by log compaction.
+type BalanceChange struct {
+	Account  string    `json:"account"`
+	Balances []Balance `json:"balances"`
+	// PaymentPartition and PaymentOffset tell which payment caused the change.
+	PaymentPartition int32 `json:"payment_partition"`
+	PaymentOffset    int64 `json:"payment_offset"`
+	// Partition is a number of a partition where the change was stored.
+	Partition int32 `json:"-"`
+	// SequenceID is ID assigned internally. For example, in Kafka it is an offset of the message.
+	SequenceID int64 `json:"-"`
+}
+
+// PaymentID returns an idempotency key of a payment for duplicate suppression,
+// e.g., "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11:outgoing:Alice".
+// Both payments of a transfer share the request ID, so direction and account tell them apart.
+// A payment which reverses Bob's incoming payment has "reversal" direction in its ID.
+func PaymentID(requestID, direction, account string) string {
+	return requestID + ":" + direction + ":" + account
 }
 
 // TransferService represents a service to store transfer requests.
+// CreateTransfer may return ErrTransferExists along with the original transfer in t when the request is retried,
+// and ErrTransferConflict when the request ID was used for a different transfer.
+// CommitOffset marks the transfer at offset as processed, so FromOffset resumes after it with OffsetCommitted.
+// FromTime reads the partition starting at the first transfer created at or after since.
 type TransferService interface {
 	CreateTransfer(ctx context.Context, t *Transfer) error
 	FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *Transfer, <-chan error)
+	FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *Transfer, <-chan error)
+	CommitOffset(ctx context.Context, partition int32, offset int64) error
 }
 
 // PaymentService represents a service to store payments which are created based on
 // transfer requests.
+// CommitOffset marks the payment at offset as processed, so FromOffset resumes after it with OffsetCommitted.
+// FromTime reads the partition starting at the first payment created at or after since.
 type PaymentService interface {
 	CreatePayment(ctx context.Context, p *Payment) error
 	FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *Payment, <-chan error)
+	FromTime(ctx context.Context, partition int32, since time.Time) (<-chan *Payment, <-chan error)
+	CommitOffset(ctx context.Context, partition int32, offset int64) error
+}
+
+// RejectionService represents a service to store payment rejections.
+// CommitOffset marks the rejection at offset as processed, so FromOffset resumes after it with OffsetCommitted.
+type RejectionService interface {
+	CreateRejection(ctx context.Context, r *Rejection) error
+	FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *Rejection, <-chan error)
+	CommitOffset(ctx context.Context, partition int32, offset int64) error
+}
+
+// TransferEventService represents a service to store transfer status changes.
+type TransferEventService interface {
+	CreateEvent(ctx context.Context, e *TransferEvent) error
+	FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *TransferEvent, <-chan error)
+}
+
+// BalanceChangeService represents a service to store balance changes keyed by account.
+// Latest returns the latest change of each account stored in the partition by the time of the call.
+type BalanceChangeService interface {
+	CreateBalanceChange(ctx context.Context, b *BalanceChange) error
+	FromOffset(ctx context.Context, partition int32, offset int64) (<-chan *BalanceChange, <-chan error)
+	Latest(ctx context.Context, partition int32) (map[string]*BalanceChange, error)
+}
+
+// TransferStatusService keeps track of transfers' statuses based on transfer events.
+type TransferStatusService interface {
+	// Transfer returns the transfer with its current status or ErrTransferNotFound.
+	Transfer(requestID string) (*Transfer, error)
+	ApplyEvent(e *TransferEvent) error
 }
 
 // DedupService is responsible for requests deduplication.
+// For example, it could be a transfer request ID or a payment ID.
 type DedupService interface {
-	HasSeen(requestID string) (bool, error)
-	Save(requestID string) error
+	HasSeen(id string) (bool, error)
+	Save(id string) error
+}
+
+// BalanceService is responsible for storing account balances.
+// An account has a separate balance in each currency, Balances returns all of them.
+type BalanceService interface {
+	Balance(account, currency string) (apd.Decimal, error)
+	Balances(account string) ([]Balance, error)
+	SetBalance(account, currency string, bal apd.Decimal) error
+}
+
+// LedgerService applies payments to account balances. A payment ID,
+// the new account balance and the payment's offset are saved atomically,
+// so a payment is neither lost nor applied twice when a process crashes.
+type LedgerService interface {
+	ApplyPayment(p *Payment, bal apd.Decimal) error
+	// SetOffset saves the offset of a payment which didn't change a balance, e.g., a duplicate,
+	// so the offset keeps up with the processed payments.
+	SetOffset(offset int64) error
+	// Offset returns the offset of the last applied payment.
+	Offset() (int64, error)
 }
@@ -0,0 +1,74 @@
+package wallet_test
+
+import (
+	"testing"
+	"time"
+
+	wallet "github.com/marselester/distributed-payment"
+)
+
+func TestTransfer_ApplyEvent(t *testing.T) {
+	const requestID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	t0 := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	accepted := wallet.TransferEvent{
+		RequestID: requestID,
+		Status:    wallet.StatusAccepted,
+		Time:      t0,
+		Transfer:  &wallet.Transfer{ID: requestID, From: "Alice", To: "Bob"},
+	}
+	debited := wallet.TransferEvent{RequestID: requestID, Status: wallet.StatusDebited, Time: t0.Add(2 * time.Second)}
+	credited := wallet.TransferEvent{RequestID: requestID, Status: wallet.StatusCredited, Time: t0.Add(time.Second)}
+	rejected := wallet.TransferEvent{RequestID: requestID, Status: wallet.StatusRejected, Time: t0.Add(2 * time.Second)}
+
+	tests := []struct {
+		name   string
+		events []wallet.TransferEvent
+		want   wallet.TransferStatus
+	}{
+		{
+			name:   "accepted",
+			events: []wallet.TransferEvent{accepted},
+			want:   wallet.StatusAccepted,
+		},
+		{
+			name:   "credited before accepted",
+			events: []wallet.TransferEvent{credited, accepted},
+			want:   wallet.StatusCredited,
+		},
+		{
+			name:   "completed",
+			events: []wallet.TransferEvent{accepted, credited, debited},
+			want:   wallet.StatusCompleted,
+		},
+		{
+			name:   "completed with duplicates",
+			events: []wallet.TransferEvent{accepted, debited, accepted, credited, debited},
+			want:   wallet.StatusCompleted,
+		},
+		{
+			name:   "rejected",
+			events: []wallet.TransferEvent{accepted, credited, rejected},
+			want:   wallet.StatusRejected,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var tr wallet.Transfer
+			for i := range tc.events {
+				tr.ApplyEvent(&tc.events[i])
+			}
+
+			if tr.Status != tc.want {
+				t.Errorf("status: %s, want %s", tr.Status, tc.want)
+			}
+			if tr.ID != requestID {
+				t.Errorf("request ID: %q, want %s", tr.ID, requestID)
+			}
+			if tr.Timestamps[tc.want].IsZero() {
+				t.Errorf("no %s timestamp: %v", tc.want, tr.Timestamps)
+			}
+		})
+	}
+}